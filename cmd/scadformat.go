@@ -19,15 +19,43 @@
 package main
 
 import (
+	"context"
 	_ "embed"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"slices"
 	"strings"
+	"syscall"
 
+	"github.com/hugheaves/scadformat/internal/cache"
+	"github.com/hugheaves/scadformat/internal/config"
 	"github.com/hugheaves/scadformat/internal/formatter"
 	"github.com/hugheaves/scadformat/internal/logutil"
+	"github.com/hugheaves/scadformat/internal/lsp"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
 
+// process exit codes
+const (
+	exitOK              = 0 // all files formatted successfully (or already formatted)
+	exitError           = 1 // one or more files could not be read or written
+	exitNeedsFormatting = 2 // check mode found one or more files that are not formatted
+	exitSyntaxError     = 3 // one or more files contain syntax errors
+)
+
+// commands
+const (
+	restoreCommand     = "restore"
+	configDumpCommand  = "config dump"
+	lspCommand         = "lsp"
+	hookInstallCommand = "hook install"
+	hookRunCommand     = "hook run"
+	cacheClearCommand  = "cache clear"
+)
+
 //go:generate sh -c "git describe > version.txt"
 //go:embed version.txt
 var gitVersion string
@@ -38,28 +66,191 @@ func main() {
 		panic(err)
 	}
 
-	var logLevel string
-	pflag.StringVar(&logLevel, "log-level", "info", "Logging level (one of debug, info, warn, or error)")
+	mainConfig := &config.MainConfig{}
+	var lineRanges []string
+	var noCache bool
+	var cursorOffset int
+	pflag.StringVar(&mainConfig.LogLevel, "log-level", "info", "Logging level (one of debug, info, warn, or error)")
+	pflag.BoolVarP(&mainConfig.Check, "check", "c", false, "Check that files are formatted, without modifying them. Lists files that are not formatted and exits with status 2")
+	pflag.BoolVarP(&mainConfig.Diff, "diff", "d", false, "Print a unified diff of the changes to stdout, without modifying files")
+	pflag.BoolVar(&mainConfig.JSON, "json", false, "Print a JSON report with the result of each file to stdout, instead of file names (--check) or diffs (--diff)")
+	pflag.BoolVarP(&mainConfig.Watch, "watch", "w", false, "Watch files and directories, and reformat .scad files when they change")
+	pflag.BoolVarP(&mainConfig.Recurse, "recursive", "r", false, "Format .scad files in subdirectories of directory arguments")
+	pflag.BoolVar(&mainConfig.RespectGitignore, "respect-gitignore", false, "Skip files and directories that are ignored by .gitignore files, in addition to .scadformatignore files")
+	pflag.IntVarP(&mainConfig.Jobs, "jobs", "j", runtime.NumCPU(), "Number of files to format in parallel")
+	pflag.StringVar(&mainConfig.StdinFilePath, "stdin-filepath", "", "Path of the file read from stdin, used to find its configuration and ignore rules, and to name it in messages")
+	pflag.IntVar(&cursorOffset, "cursor-offset", 0, "Byte offset of the cursor in stdin. The matching offset in the formatted code is written to stdout as {\"cursor\": N} on a line before the code")
+	pflag.StringArrayVar(&lineRanges, "lines", nil, "Only reformat the top level statements that overlap the lines start:end (may be repeated)")
+	pflag.StringVar(&mainConfig.Since, "since", "", "Only reformat the top level statements containing lines changed since a git revision")
+	pflag.BoolVar(&mainConfig.Staged, "staged", false, "Only reformat the top level statements containing lines with changes staged in git")
+	pflag.StringVar(&mainConfig.HookMode, "hook-mode", formatter.HookModeFix, "What the pre-commit hook does with unformatted staged files: \"fix\" formats and re-stages them, \"check\" fails with a diff")
+	pflag.BoolVar(&noCache, "no-cache", false, "Format every file, without using or updating the cache of files that are already formatted")
+	pflag.BoolVar(&mainConfig.PreserveTimestamp, "preserve-timestamp", false, "Keep the modification time of reformatted files")
+	pflag.BoolVar(&mainConfig.NoBackups, "no-backup", false, "Do not create .scadbak backups of reformatted files")
+	pflag.StringVar(&mainConfig.BackupDir, "backup-dir", "", "Write backups to this directory (mirroring the source tree) instead of next to the source files")
+	pflag.IntVar(&mainConfig.BackupCount, "backup-keep", 0, "Number of backups to keep for each file (0 keeps all backups)")
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [file or directory ...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s restore [options] file or directory ...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s config dump file ...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s lsp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s hook install [--hook-mode fix|check]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s cache clear\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "With no file or directory arguments, reads from stdin and writes to stdout.\n")
+		fmt.Fprintf(os.Stderr, "The restore command replaces files with their most recent backup.\n")
+		fmt.Fprintf(os.Stderr, "The config dump command shows the effective %s options for files.\n", config.ProjectConfigFileName)
+		fmt.Fprintf(os.Stderr, "The lsp command runs a Language Server Protocol server on stdin and stdout.\n")
+		fmt.Fprintf(os.Stderr, "The hook install command installs a git pre-commit hook that formats staged .scad files.\n")
+		fmt.Fprintf(os.Stderr, "The cache clear command removes the cache of files that are already formatted.\n\n")
+		pflag.PrintDefaults()
+	}
 	pflag.Parse()
 
-	err = logutil.ConfigureLogging(logLevel)
+	err = logutil.ConfigureLogging(mainConfig.LogLevel)
 	if err != nil {
 		zap.L().Fatal(err.Error())
 	}
 
-	zap.L().Info("SCADFormat " + strings.TrimSpace(gitVersion))
+	mainConfig.Version = strings.TrimSpace(gitVersion)
+	zap.L().Info("SCADFormat " + mainConfig.Version)
+
+	for _, lineRange := range lineRanges {
+		r, err := config.ParseLineRange(lineRange)
+		if err != nil {
+			zap.L().Fatal(err.Error())
+		}
+		mainConfig.Lines = append(mainConfig.Lines, r)
+	}
+
+	if pflag.CommandLine.Changed("cursor-offset") {
+		if cursorOffset < 0 {
+			zap.L().Fatal("--cursor-offset must not be negative")
+		}
+		mainConfig.CursorOffset = &cursorOffset
+	}
+
+	mainConfig.TargetPaths = pflag.Args()
+	parseCommand(mainConfig)
+
+	cacheDir, err := cache.DefaultDir()
+	if err != nil {
+		zap.S().Warnf("cache disabled: %s", err)
+	} else if !noCache {
+		mainConfig.CacheDir = cacheDir
+	}
+
+	switch mainConfig.Command {
+	case restoreCommand:
+		err = formatter.NewFormatter(mainConfig).Restore()
+		if err != nil {
+			zap.L().Fatal(err.Error())
+		}
+		return
+	case configDumpCommand:
+		err = formatter.NewFormatter(mainConfig).DumpConfig()
+		if err != nil {
+			zap.L().Fatal(err.Error())
+		}
+		return
+	case lspCommand:
+		err = lsp.NewServer(formatter.NewFormatter(mainConfig), os.Stdin, os.Stdout, strings.TrimSpace(gitVersion)).Run()
+		if err != nil {
+			zap.L().Fatal(err.Error())
+		}
+		return
+	case hookInstallCommand:
+		err = formatter.NewFormatter(mainConfig).InstallHook()
+		if err != nil {
+			zap.L().Fatal(err.Error())
+		}
+		return
+	case cacheClearCommand:
+		if cacheDir == "" {
+			zap.L().Fatal("no cache directory")
+		}
+		err = cache.New(cacheDir).Clear()
+		if err != nil {
+			zap.L().Fatal(err.Error())
+		}
+		zap.S().Infof("cleared cache %s", cacheDir)
+		return
+	case hookRunCommand:
+		summary, err := formatter.NewFormatter(mainConfig).FormatStaged()
+		if err != nil {
+			zap.L().Fatal(err.Error())
+		}
+		os.Exit(exitCode(mainConfig, summary))
+	}
+
+	if mainConfig.StdinFilePath != "" && len(mainConfig.TargetPaths) > 0 {
+		zap.L().Fatal("--stdin-filepath can only be used when formatting stdin")
+	}
+
+	if mainConfig.Since != "" || mainConfig.Staged {
+		if mainConfig.Since != "" && mainConfig.Staged {
+			zap.L().Fatal("--since cannot be combined with --staged")
+		}
+		if len(mainConfig.Lines) > 0 || mainConfig.StdinFilePath != "" || mainConfig.Watch {
+			zap.L().Fatal("--since and --staged cannot be combined with --lines, --stdin-filepath or --watch")
+		}
+	}
+
+	if mainConfig.CursorOffset != nil && (len(mainConfig.TargetPaths) > 0 || mainConfig.Check || mainConfig.Diff || mainConfig.JSON || mainConfig.Since != "" || mainConfig.Staged) {
+		zap.L().Fatal("--cursor-offset can only be used when formatting stdin to stdout")
+	}
 
-	var fileName string
-	if len(pflag.Args()) == 1 {
-		fileName = pflag.Arg(0)
-	} else if len(pflag.Args()) > 1 {
-		zap.L().Fatal("only a single filename may be specified on the command line")
+	if mainConfig.JSON && len(mainConfig.TargetPaths) == 0 && !mainConfig.Check && !mainConfig.Diff && mainConfig.Since == "" && !mainConfig.Staged {
+		zap.L().Fatal("--json requires --check or --diff when formatting stdin")
 	}
 
-	formatter := formatter.NewFormatter(fileName)
-	err = formatter.Format()
+	if mainConfig.Watch {
+		if mainConfig.Check || mainConfig.Diff || mainConfig.JSON {
+			zap.L().Fatal("--watch cannot be combined with --check, --diff or --json")
+		}
+		if len(mainConfig.Lines) > 0 {
+			zap.L().Fatal("--watch cannot be combined with --lines")
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		err = formatter.NewFormatter(mainConfig).Watch(ctx)
+		if err != nil {
+			zap.L().Fatal(err.Error())
+		}
+		return
+	}
+
+	summary, err := formatter.NewFormatter(mainConfig).Format()
 	if err != nil {
 		zap.L().Fatal(err.Error())
 	}
+	os.Exit(exitCode(mainConfig, summary))
+}
 
+// parseCommand removes the command (if any) from the start of the target paths,
+// and stores it in the config.
+func parseCommand(mainConfig *config.MainConfig) {
+	for _, command := range []string{restoreCommand, configDumpCommand, lspCommand, hookInstallCommand, hookRunCommand, cacheClearCommand} {
+		words := strings.Fields(command)
+		if len(mainConfig.TargetPaths) >= len(words) && slices.Equal(mainConfig.TargetPaths[:len(words)], words) {
+			mainConfig.Command = command
+			mainConfig.TargetPaths = mainConfig.TargetPaths[len(words):]
+			return
+		}
+	}
+}
+
+// exitCode returns the process exit code for the results of a formatting run.
+// I/O errors take precedence over syntax errors, which take precedence over
+// unformatted files, which are only reported as an error in check mode.
+func exitCode(mainConfig *config.MainConfig, summary *formatter.Summary) int {
+	switch {
+	case summary.Count(formatter.StatusIOError) > 0:
+		return exitError
+	case summary.Count(formatter.StatusSyntaxError) > 0:
+		return exitSyntaxError
+	case summary.Count(formatter.StatusWouldReformat) > 0 && mainConfig.Check:
+		return exitNeedsFormatting
+	default:
+		return exitOK
+	}
 }
@@ -0,0 +1,189 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+// Package format formats OpenSCAD source code.
+//
+// The functions in this package only operate on the source code that they are
+// given: they do not read configuration files or access the filesystem. They
+// are safe to call from multiple goroutines at once.
+package format
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/hugheaves/scadformat/internal/config"
+	"github.com/hugheaves/scadformat/internal/formatter"
+	"go.uber.org/zap"
+)
+
+// Options controls how source code is formatted. The zero value formats code
+// the same way as the scadformat command without any configuration files.
+type Options struct {
+	IndentSize             int         // number of columns per indentation level (0 for the default of 2)
+	UseTabs                bool        // indent with tabs instead of spaces
+	TabWidth               int         // number of columns a tab is displayed as, when measuring line lengths (0 for the indent size)
+	MaxLineLength          int         // maximum line length (0 for no limit)
+	EndOfLine              string      // line ending: "\n" (the default if empty), "\r\n" or "\r"
+	NoFinalNewline         bool        // don't end the output with a line ending
+	TrimTrailingWhitespace bool        // remove whitespace at the end of lines
+	ModuleBraceStyle       string      // brace placement for module definitions: "kr" (the default if empty) or "allman"
+	ControlBraceStyle      string      // brace placement for if, else and for: "kr" (the default if empty) or "allman"
+	ChildrenBraceStyle     string      // brace placement for the children of other modules: "kr" (the default if empty) or "allman"
+	FunctionBodyStyle      string      // function body placement: "break" (the default if empty), "fit" or "break_complex"
+	Lines                  []LineRange // only reformat the top level statements that overlap these lines (nil for all)
+	Logger                 *zap.Logger // receives debug messages (nil to disable logging)
+}
+
+// LineRange is a range of lines, numbered from 1. The range includes both the
+// start and end lines.
+type LineRange = config.LineRange
+
+// SyntaxError is an error reported by the parser, at a line and column of the
+// source code.
+type SyntaxError = formatter.SyntaxError
+
+// SyntaxErrors is the error returned when the source code cannot be parsed. It
+// holds every SyntaxError reported by the parser, and unwraps to the last one,
+// so errors.As can be used with either type.
+type SyntaxErrors = formatter.SyntaxErrors
+
+var endOfLineOptions = map[string]string{
+	"\n":   config.EndOfLineLF,
+	"\r\n": config.EndOfLineCRLF,
+	"\r":   config.EndOfLineCR,
+}
+
+// Format returns the formatted source code. If the source contains syntax
+// errors, a *SyntaxErrors is returned.
+//
+// If line ranges are given, only the top level statements (e.g. module
+// definitions) that overlap them are reformatted, and the rest of the source is
+// returned unchanged. The whole source must still be free of syntax errors.
+func Format(src []byte, opts Options) ([]byte, error) {
+	output, _, err := format(src, -1, opts)
+	return output, err
+}
+
+// FormatWithCursor formats source code like Format, and also returns the new
+// position of a cursor. The cursor is a byte offset in src, and the returned
+// cursor is the matching byte offset in the formatted code: the cursor stays at
+// the same place within the token that contains it (or the token before it, if
+// the cursor is between tokens). Editors can use this to keep the cursor in
+// place when they replace the whole buffer with the formatted code.
+func FormatWithCursor(src []byte, cursor int, opts Options) ([]byte, int, error) {
+	if cursor < 0 || cursor > len(src) {
+		return nil, 0, fmt.Errorf("cursor offset %d is outside the source code", cursor)
+	}
+	return format(src, cursor, opts)
+}
+
+func format(src []byte, cursor int, opts Options) ([]byte, int, error) {
+	options, err := opts.formatOptions()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, r := range opts.Lines {
+		err = r.Validate()
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	return formatter.FormatSource(src, options, opts.Lines, cursor, logger)
+}
+
+// FormatReader reads source code from r, and writes the formatted code to w.
+// Nothing is written if the source code cannot be formatted.
+func FormatReader(w io.Writer, r io.Reader, opts Options) error {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	output, err := Format(src, opts)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(output)
+	return err
+}
+
+// formatOptions converts the options to the options used by the formatter.
+func (o *Options) formatOptions() (*config.FormatOptions, error) {
+	if o.IndentSize < 0 {
+		return nil, fmt.Errorf("invalid indent size %d", o.IndentSize)
+	}
+	if o.TabWidth < 0 {
+		return nil, fmt.Errorf("invalid tab width %d", o.TabWidth)
+	}
+	if o.MaxLineLength < 0 {
+		return nil, fmt.Errorf("invalid maximum line length %d", o.MaxLineLength)
+	}
+
+	options := &config.FormatOptions{}
+	if o.IndentSize > 0 {
+		options.IndentSize = &o.IndentSize
+	}
+	indentStyle := config.IndentStyleSpace
+	if o.UseTabs {
+		indentStyle = config.IndentStyleTab
+	}
+	options.IndentStyle = &indentStyle
+	if o.TabWidth > 0 {
+		options.TabWidth = &o.TabWidth
+	}
+	options.MaxLineLength = &o.MaxLineLength
+	if o.EndOfLine != "" {
+		endOfLine, ok := endOfLineOptions[o.EndOfLine]
+		if !ok {
+			return nil, fmt.Errorf("invalid line ending %q", o.EndOfLine)
+		}
+		options.EndOfLine = &endOfLine
+	}
+	insertFinalNewline := !o.NoFinalNewline
+	options.InsertFinalNewline = &insertFinalNewline
+	options.TrimTrailingWhitespace = &o.TrimTrailingWhitespace
+	for _, style := range []struct {
+		value  string
+		option **string
+	}{
+		{o.ModuleBraceStyle, &options.ModuleBraceStyle},
+		{o.ControlBraceStyle, &options.ControlBraceStyle},
+		{o.ChildrenBraceStyle, &options.ChildrenBraceStyle},
+	} {
+		if style.value == "" {
+			continue
+		}
+		if style.value != config.BraceStyleKR && style.value != config.BraceStyleAllman {
+			return nil, fmt.Errorf("invalid brace style %q", style.value)
+		}
+		value := style.value
+		*style.option = &value
+	}
+	switch o.FunctionBodyStyle {
+	case "":
+	case config.FunctionBodyBreak, config.FunctionBodyFit, config.FunctionBodyBreakComplex:
+		options.FunctionBodyStyle = &o.FunctionBodyStyle
+	default:
+		return nil, fmt.Errorf("invalid function body style %q", o.FunctionBodyStyle)
+	}
+	return options, nil
+}
@@ -0,0 +1,139 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package format
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const source = "module a(){cube(1);}\n"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		opts     Options
+		expected string
+	}{
+		{Options{}, "module a() {\n  cube(1);\n}\n"},
+		{Options{IndentSize: 4}, "module a() {\n    cube(1);\n}\n"},
+		{Options{UseTabs: true}, "module a() {\n\tcube(1);\n}\n"},
+		{Options{ModuleBraceStyle: "allman"}, "module a()\n{\n  cube(1);\n}\n"},
+		{Options{EndOfLine: "\r\n", NoFinalNewline: true}, "module a() {\r\n  cube(1);\r\n}"},
+		{Options{Lines: []LineRange{{Start: 2, End: 3}}}, source},
+	}
+	for _, test := range tests {
+		output, err := Format([]byte(source), test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != test.expected {
+			t.Errorf("%+v: expected %q, got %q", test.opts, test.expected, string(output))
+		}
+	}
+}
+
+func TestFormatSyntaxError(t *testing.T) {
+	_, err := Format([]byte("x = ;\n"), Options{})
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected a syntax error, got %v", err)
+	}
+	if syntaxErr.Line != 1 {
+		t.Errorf("expected error on line 1, got %d", syntaxErr.Line)
+	}
+}
+
+func TestFormatSyntaxErrors(t *testing.T) {
+	_, err := Format([]byte("x = 1;\ny = ;\n"), Options{})
+	var syntaxErrs *SyntaxErrors
+	if !errors.As(err, &syntaxErrs) {
+		t.Fatalf("expected syntax errors, got %v", err)
+	}
+	if len(syntaxErrs.Errors) == 0 {
+		t.Fatal("expected the syntax errors to be listed")
+	}
+	if last := syntaxErrs.Errors[len(syntaxErrs.Errors)-1]; last.Line != 2 || last.Column != 4 {
+		t.Errorf("expected error at 2:4, got %d:%d", last.Line, last.Column)
+	}
+}
+
+func TestFormatInvalidOptions(t *testing.T) {
+	for _, opts := range []Options{{IndentSize: -1}, {MaxLineLength: -1}, {EndOfLine: "\n\r"}, {ControlBraceStyle: "gnu"}, {FunctionBodyStyle: "never"}, {Lines: []LineRange{{Start: 2, End: 1}}}} {
+		_, err := Format([]byte(source), opts)
+		if err == nil {
+			t.Errorf("%+v: expected error", opts)
+		}
+	}
+}
+
+func TestFormatWithCursor(t *testing.T) {
+	// the cursor is before "cube"
+	output, cursor, err := FormatWithCursor([]byte(source), strings.Index(source, "cube"), Options{IndentSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(output[cursor:]), "cube(1);") {
+		t.Errorf("expected cursor before cube, got %q", output[cursor:])
+	}
+
+	_, _, err = FormatWithCursor([]byte(source), len(source)+1, Options{})
+	if err == nil {
+		t.Error("expected an error for a cursor after the end of the source")
+	}
+}
+
+func TestFormatReader(t *testing.T) {
+	var output bytes.Buffer
+	err := FormatReader(&output, strings.NewReader(source), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "module a() {\n  cube(1);\n}\n" {
+		t.Errorf("unexpected output %q", output.String())
+	}
+
+	output.Reset()
+	err = FormatReader(&output, strings.NewReader("x = ;\n"), Options{})
+	if err == nil || output.Len() != 0 {
+		t.Errorf("expected an error and no output, got %v and %q", err, output.String())
+	}
+}
+
+func TestFormatConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(indentSize int) {
+			defer wg.Done()
+			output, err := Format([]byte(source), Options{IndentSize: indentSize})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			expected := "module a() {\n" + strings.Repeat(" ", indentSize) + "cube(1);\n}\n"
+			if string(output) != expected {
+				t.Errorf("expected %q, got %q", expected, string(output))
+			}
+		}(i%4 + 1)
+	}
+	wg.Wait()
+}
@@ -3,7 +3,9 @@ module github.com/hugheaves/scadformat
 go 1.24.0
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/antlr4-go/antlr/v4 v4.13.1
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/hexops/gotextdiff v1.0.3
 	github.com/spf13/pflag v1.0.10
 	go.uber.org/zap v1.27.0
@@ -12,4 +14,5 @@ require (
 require (
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/exp v0.0.0-20250819193227-8b4c13bb791b // indirect
+	golang.org/x/sys v0.13.0 // indirect
 )
@@ -0,0 +1,198 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+// Package cache records which source code is already formatted, so that
+// formatting it again can be skipped.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	dirName       = "scadformat"
+	pruneFileName = "last-prune"        // file whose modification time is when the cache was last pruned
+	maxEntryAge   = 30 * 24 * time.Hour // entries that aren't used for this long are removed
+	pruneInterval = 24 * time.Hour      // how often the cache is pruned
+	touchInterval = 24 * time.Hour      // how often the modification time of an entry is updated when it is used
+)
+
+// Cache is a directory containing an empty file for each key that has been
+// added. Keys are hashes of source code, the settings used to format it and the
+// formatter executable, so entries never become invalid, and the cache can be
+// shared by many processes. The modification time of each entry is the last
+// time it was used, so that entries that are no longer used can be pruned.
+type Cache struct {
+	dir string
+}
+
+// New returns a cache that is stored in dir. The directory is created when the
+// first key is added.
+func New(dir string) *Cache {
+	return &Cache{dir: dir}
+}
+
+// DefaultDir returns the default cache directory, which is $XDG_CACHE_HOME/scadformat
+// on Linux, and the equivalent user cache directory on other systems.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, dirName), nil
+}
+
+// ExecutableHash returns a hash of the running executable. Cache keys include
+// it rather than the version of the formatter, as every build from the same
+// version would otherwise share entries, even if they format code differently.
+var ExecutableHash = sync.OnceValues(func() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+})
+
+// Key returns the cache key for source code, formatted with the settings
+// described by fingerprint by the formatter executable with the given hash.
+func Key(executableHash string, fingerprint string, content []byte) string {
+	hash := sha256.New()
+	for _, part := range [][]byte{[]byte(executableHash), []byte(fingerprint), content} {
+		// prefix each part with its length, so that parts can't run into each other
+		fmt.Fprintf(hash, "%d:", len(part))
+		hash.Write(part)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Has returns true if the key has been added to the cache.
+func (c *Cache) Has(key string) bool {
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	// to limit writes, the time that an entry was last used is only approximate
+	if now := time.Now(); now.Sub(info.ModTime()) > touchInterval {
+		_ = os.Chtimes(path, now, now)
+	}
+	return true
+}
+
+// Add adds a key to the cache.
+func (c *Cache) Add(key string) error {
+	path := c.path(key)
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	err = os.WriteFile(path, nil, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	return nil
+}
+
+// Prune removes the entries that haven't been used for a month. As this reads
+// the whole cache, it is only done once a day, and Prune does nothing if the
+// cache was pruned more recently.
+func (c *Cache) Prune() error {
+	pruneFile := filepath.Join(c.dir, pruneFileName)
+	info, err := os.Stat(pruneFile)
+	if err == nil && time.Since(info.ModTime()) < pruneInterval {
+		return nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to prune cache: %w", err)
+	}
+	subdirs, err := os.ReadDir(c.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to prune cache: %w", err)
+	}
+	// other processes skip pruning while this one prunes
+	err = os.WriteFile(pruneFile, nil, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to prune cache: %w", err)
+	}
+
+	cutoff := time.Now().Add(-maxEntryAge)
+	for _, subdir := range subdirs {
+		if !subdir.IsDir() {
+			continue
+		}
+		dir := filepath.Join(c.dir, subdir.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to prune cache: %w", err)
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil || !info.ModTime().Before(cutoff) {
+				continue
+			}
+			err = os.Remove(filepath.Join(dir, entry.Name()))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to prune cache: %w", err)
+			}
+		}
+		// fails if the directory still has entries
+		_ = os.Remove(dir)
+	}
+	return nil
+}
+
+// Clear removes all entries from the cache.
+func (c *Cache) Clear() error {
+	err := os.RemoveAll(c.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to clear cache: %w", err)
+	}
+	return nil
+}
+
+// Dir returns the directory that the cache is stored in.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// path returns the path of the file for a key. Entries are spread over
+// subdirectories, to keep the size of each directory down.
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key[2:])
+}
@@ -0,0 +1,119 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "scadformat"))
+	key := Key("v1", "indent=2", []byte("x = 1;\n"))
+	if c.Has(key) {
+		t.Fatal("expected empty cache")
+	}
+	err := c.Add(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Has(key) {
+		t.Fatal("expected key to be cached")
+	}
+
+	for _, other := range []string{
+		Key("v2", "indent=2", []byte("x = 1;\n")),
+		Key("v1", "indent=4", []byte("x = 1;\n")),
+		Key("v1", "indent=2", []byte("x = 2;\n")),
+		Key("v1", "indent=2x", []byte(" = 1;\n")),
+	} {
+		if c.Has(other) {
+			t.Errorf("unexpected cache hit for %s", other)
+		}
+	}
+
+	err = c.Clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Has(key) {
+		t.Fatal("expected cache to be cleared")
+	}
+	err = c.Clear()
+	if err != nil {
+		t.Fatalf("clearing an empty cache: %s", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	c := New(filepath.Join(t.TempDir(), "scadformat"))
+	old, used, recent := Key("", "", []byte("old")), Key("", "", []byte("used")), Key("", "", []byte("recent"))
+	for _, key := range []string{old, used, recent} {
+		err := c.Add(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	age := time.Now().Add(-2 * maxEntryAge)
+	for _, key := range []string{old, used} {
+		err := os.Chtimes(c.path(key), age, age)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// using an entry keeps it in the cache
+	if !c.Has(used) {
+		t.Fatal("expected key to be cached")
+	}
+
+	err := c.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]bool{old: false, used: true, recent: true} {
+		if _, err := os.Stat(c.path(key)); (err == nil) != expected {
+			t.Errorf("%s: expected cached %t", key, expected)
+		}
+	}
+
+	// the cache is only pruned once a day
+	err = os.Chtimes(c.path(recent), age, age)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.path(recent)); err != nil {
+		t.Error("expected the cache not to be pruned again")
+	}
+}
+
+func TestExecutableHash(t *testing.T) {
+	hash, err := ExecutableHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := ExecutableHash(); len(hash) != 64 || again != hash {
+		t.Errorf("unexpected hash %q", hash)
+	}
+}
@@ -19,10 +19,26 @@
 package config
 
 type MainConfig struct {
-	LogLevel          string // logging level - error, warn, info, etc.
-	Watch             bool   // filesystem "watch mode" is enabled
-	Recurse           bool   // when target is a directory, apply operation recursively
-	NoBackups         bool   // do not create backups of modified files
-	PreserveTimestamp bool   // preserve the modified time on reformatted files
-	TargetPath        string // the target path of the operation(fie or directory)
+	Command           string      // the command to run (e.g. "restore"), or "" to format files
+	LogLevel          string      // logging level - error, warn, info, etc.
+	Watch             bool        // filesystem "watch mode" is enabled
+	Recurse           bool        // when target is a directory, apply operation recursively
+	NoBackups         bool        // do not create backups of modified files
+	BackupDir         string      // directory to write backups to, instead of next to the modified files
+	BackupCount       int         // number of backups to keep for each file (0 keeps all backups)
+	Check             bool        // report unformatted files instead of reformatting them
+	Diff              bool        // print a diff of the changes instead of reformatting files
+	JSON              bool        // print a JSON report of the results instead of file names or diffs
+	PreserveTimestamp bool        // preserve the modified time on reformatted files
+	RespectGitignore  bool        // skip files that are ignored by .gitignore files
+	Jobs              int         // number of files to format in parallel
+	StdinFilePath     string      // the path of the file read from stdin, used to find its configuration
+	Lines             []LineRange // only reformat the top level statements that overlap these lines
+	Since             string      // only reformat the lines changed since this git revision
+	Staged            bool        // only reformat the lines with changes staged in git
+	HookMode          string      // what the pre-commit hook does with unformatted files ("fix" or "check")
+	CursorOffset      *int        // byte offset of the cursor in stdin, which is mapped to the output (nil if not tracked)
+	CacheDir          string      // directory of the cache of formatted files, or "" to disable the cache
+	Version           string      // version of scadformat
+	TargetPaths       []string    // the target paths of the operation (files or directories)
 }
@@ -0,0 +1,215 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hugheaves/scadformat/internal/glob"
+)
+
+// EditorConfigFileName is the name of EditorConfig files. See https://editorconfig.org
+const EditorConfigFileName = ".editorconfig"
+
+// editorConfigFile is a parsed .editorconfig file.
+type editorConfigFile struct {
+	path     string
+	root     bool
+	sections []*editorConfigSection
+}
+
+type editorConfigSection struct {
+	name       string
+	glob       *glob.Glob
+	properties map[string]string
+	lines      map[string]int // line number of each property, used to report where options were set
+}
+
+// editorConfigProperty is the value of a property, and where it was set.
+type editorConfigProperty struct {
+	value  string
+	source string
+}
+
+// readEditorConfigFile parses an .editorconfig file, returning nil if the file
+// does not exist. Properties are parsed following the EditorConfig specification:
+// property names, and the values of the properties used by SCADFormat, are case
+// insensitive.
+func readEditorConfigFile(path string) (*editorConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	configFile := &editorConfigFile{path: path}
+	var section *editorConfigSection
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.LastIndex(line, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid section header", path, lineNumber)
+			}
+			name := line[1:end]
+			g, err := compileEditorConfigGlob(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+			}
+			section = &editorConfigSection{
+				name:       name,
+				glob:       g,
+				properties: make(map[string]string),
+				lines:      make(map[string]int),
+			}
+			configFile.sections = append(configFile.sections, section)
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%s:%d: expected \"name = value\"", path, lineNumber)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if section == nil {
+			// the preamble may only contain "root"
+			if key == "root" {
+				configFile.root = strings.EqualFold(value, "true")
+			}
+			continue
+		}
+		section.properties[key] = value
+		section.lines[key] = lineNumber
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return configFile, nil
+}
+
+// compileEditorConfigGlob compiles a section name. Names containing a "/" are
+// matched relative to the directory of the .editorconfig file (a leading "/" is
+// optional), and other names are matched against files in any directory.
+func compileEditorConfigGlob(name string) (*glob.Glob, error) {
+	if strings.Contains(name, "/") {
+		return glob.Compile(strings.TrimPrefix(name, "/"))
+	}
+	return glob.Compile("**/" + name)
+}
+
+// resolveEditorConfig returns the EditorConfig properties that apply to a file.
+// Files are ordered from the nearest to the furthest, and properties in nearer
+// files (and later sections within a file) take precedence.
+func resolveEditorConfig(configFiles []*editorConfigFile, absFileName string) (map[string]editorConfigProperty, error) {
+	properties := make(map[string]editorConfigProperty)
+	for i := len(configFiles) - 1; i >= 0; i-- {
+		configFile := configFiles[i]
+		relPath, err := filepath.Rel(filepath.Dir(configFile.path), absFileName)
+		if err != nil {
+			return nil, err
+		}
+		relPath = filepath.ToSlash(relPath)
+		for _, section := range configFile.sections {
+			if !section.glob.Match(relPath) {
+				continue
+			}
+			source := fmt.Sprintf("%s [%s]", configFile.path, section.name)
+			for key, value := range section.properties {
+				if strings.EqualFold(value, "unset") {
+					delete(properties, key)
+					continue
+				}
+				properties[key] = editorConfigProperty{value: value, source: fmt.Sprintf("%s:%d", source, section.lines[key])}
+			}
+		}
+	}
+	return properties, nil
+}
+
+// mergeEditorConfig sets the format options that correspond to EditorConfig
+// properties. Properties with values that SCADFormat doesn't understand are
+// ignored, as the EditorConfig specification requires.
+func (r *ResolvedOptions) mergeEditorConfig(properties map[string]editorConfigProperty) {
+	lower := func(key string) (string, string, bool) {
+		property, ok := properties[key]
+		return strings.ToLower(property.value), property.source, ok
+	}
+
+	if value, source, ok := lower("indent_style"); ok && (value == IndentStyleSpace || value == IndentStyleTab) {
+		r.merge(&FormatOptions{IndentStyle: &value}, source)
+	}
+
+	if value, source, ok := lower("indent_size"); ok {
+		if value == "tab" {
+			value, source, ok = lower("tab_width")
+		}
+		if size, err := strconv.Atoi(value); ok && err == nil && size >= 0 {
+			r.merge(&FormatOptions{IndentSize: &size}, source)
+		}
+	} else if value, source, ok := lower("tab_width"); ok && r.IndentStyle != nil && *r.IndentStyle == IndentStyleTab {
+		// indent_size defaults to tab_width when indenting with tabs
+		if size, err := strconv.Atoi(value); err == nil && size >= 0 {
+			r.merge(&FormatOptions{IndentSize: &size}, source)
+		}
+	}
+
+	if value, source, ok := lower("tab_width"); ok {
+		if width, err := strconv.Atoi(value); err == nil && width >= 1 {
+			r.merge(&FormatOptions{TabWidth: &width}, source)
+		}
+	}
+
+	if value, source, ok := lower("max_line_length"); ok {
+		if value == "off" {
+			value = "0"
+		}
+		if length, err := strconv.Atoi(value); err == nil && length >= 0 {
+			r.merge(&FormatOptions{MaxLineLength: &length}, source)
+		}
+	}
+
+	if value, source, ok := lower("end_of_line"); ok && (value == EndOfLineLF || value == EndOfLineCRLF || value == EndOfLineCR) {
+		r.merge(&FormatOptions{EndOfLine: &value}, source)
+	}
+
+	if value, source, ok := lower("insert_final_newline"); ok && (value == "true" || value == "false") {
+		insert := value == "true"
+		r.merge(&FormatOptions{InsertFinalNewline: &insert}, source)
+	}
+
+	if value, source, ok := lower("trim_trailing_whitespace"); ok && (value == "true" || value == "false") {
+		trim := value == "true"
+		r.merge(&FormatOptions{TrimTrailingWhitespace: &trim}, source)
+	}
+}
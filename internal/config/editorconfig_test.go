@@ -0,0 +1,128 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeEditorConfigFile(t *testing.T, dir string, content string) string {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, EditorConfigFileName)
+	err = os.WriteFile(path, []byte(content), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResolveEditorConfig(t *testing.T) {
+	root := t.TempDir()
+	writeEditorConfigFile(t, root, `
+[*]
+end_of_line = crlf
+`)
+	outer := writeEditorConfigFile(t, filepath.Join(root, "project"), `
+# comment
+root = true
+
+[*]
+indent_style = space
+indent_size = 4
+max_line_length = 100
+
+[*.scad]
+indent_style = TAB
+indent_size = tab
+tab_width = 8
+insert_final_newline = false
+
+[lib/**.scad]
+max_line_length = off
+`)
+	inner := writeEditorConfigFile(t, filepath.Join(root, "project", "vendor"), `
+[*.scad]
+indent_style = unset
+indent_size = unset
+trim_trailing_whitespace = true
+`)
+	defaults := FormatOptions{IndentSize: intOption(2), MaxLineLength: intOption(0)}
+	loader := NewProjectConfigLoader()
+
+	resolved, err := loader.Resolve(filepath.Join(root, "project", "main.scad"), defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *resolved.IndentStyle != IndentStyleTab || resolved.Source("indent_style") != outer+" [*.scad]:11" {
+		t.Errorf("unexpected indent_style %s from %s", *resolved.IndentStyle, resolved.Source("indent_style"))
+	}
+	if *resolved.IndentSize != 8 || resolved.Source("indent_size") != outer+" [*.scad]:13" {
+		t.Errorf("unexpected indent_size %d from %s", *resolved.IndentSize, resolved.Source("indent_size"))
+	}
+	if *resolved.TabWidth != 8 || resolved.Source("tab_width") != outer+" [*.scad]:13" {
+		t.Errorf("unexpected tab_width %d from %s", *resolved.TabWidth, resolved.Source("tab_width"))
+	}
+	if *resolved.MaxLineLength != 100 || *resolved.InsertFinalNewline {
+		t.Errorf("unexpected max_line_length %d, insert_final_newline %t", *resolved.MaxLineLength, *resolved.InsertFinalNewline)
+	}
+	if resolved.EndOfLine != nil {
+		t.Errorf("end_of_line set from %s, beyond the root .editorconfig", resolved.Source("end_of_line"))
+	}
+
+	resolved, err = loader.Resolve(filepath.Join(root, "project", "lib", "part.scad"), defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *resolved.MaxLineLength != 0 {
+		t.Errorf("expected max_line_length 0, got %d", *resolved.MaxLineLength)
+	}
+
+	resolved, err = loader.Resolve(filepath.Join(root, "project", "vendor", "part.scad"), defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *resolved.IndentSize != 2 || resolved.Source("indent_size") != "default" {
+		t.Errorf("expected unset indent_size to use the default, got %d from %s", *resolved.IndentSize, resolved.Source("indent_size"))
+	}
+	if !*resolved.TrimTrailingWhitespace || resolved.Source("trim_trailing_whitespace") != inner+" [*.scad]:5" {
+		t.Errorf("unexpected trim_trailing_whitespace from %s", resolved.Source("trim_trailing_whitespace"))
+	}
+}
+
+func TestProjectConfigOverridesEditorConfig(t *testing.T) {
+	root := t.TempDir()
+	writeEditorConfigFile(t, root, "[*]\nindent_size = 4\nend_of_line = crlf\n")
+	configFile := writeConfigFile(t, root, "indent_size = 3\n")
+
+	resolved, err := NewProjectConfigLoader().Resolve(filepath.Join(root, "part.scad"), FormatOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *resolved.IndentSize != 3 || resolved.Source("indent_size") != configFile {
+		t.Errorf("expected indent_size 3 from %s, got %d from %s", configFile, *resolved.IndentSize, resolved.Source("indent_size"))
+	}
+	if *resolved.EndOfLine != EndOfLineCRLF {
+		t.Errorf("expected end_of_line crlf, got %s", *resolved.EndOfLine)
+	}
+}
@@ -0,0 +1,74 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LineRange is a range of lines in a source file. Lines are numbered from 1,
+// and the range includes both the start and end lines.
+type LineRange struct {
+	Start int
+	End   int
+}
+
+// ParseLineRange parses a line range in the form "start:end".
+func ParseLineRange(s string) (LineRange, error) {
+	start, end, found := strings.Cut(s, ":")
+	if !found {
+		return LineRange{}, fmt.Errorf("invalid line range %q: expected start:end", s)
+	}
+	r := LineRange{}
+	var err error
+	r.Start, err = strconv.Atoi(start)
+	if err != nil {
+		return LineRange{}, fmt.Errorf("invalid line range %q: %w", s, err)
+	}
+	r.End, err = strconv.Atoi(end)
+	if err != nil {
+		return LineRange{}, fmt.Errorf("invalid line range %q: %w", s, err)
+	}
+	err = r.Validate()
+	if err != nil {
+		return LineRange{}, err
+	}
+	return r, nil
+}
+
+// Validate checks that the range starts at line 1 or later, and doesn't end
+// before it starts.
+func (r LineRange) Validate() error {
+	if r.Start < 1 || r.End < r.Start {
+		return fmt.Errorf("invalid line range %s", r)
+	}
+	return nil
+}
+
+// Overlaps returns true if any of the lines from first to last (inclusive) are
+// in the range.
+func (r LineRange) Overlaps(first int, last int) bool {
+	return first <= r.End && last >= r.Start
+}
+
+func (r LineRange) String() string {
+	return fmt.Sprintf("%d:%d", r.Start, r.End)
+}
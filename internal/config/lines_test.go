@@ -0,0 +1,41 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package config
+
+import "testing"
+
+func TestParseLineRange(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected LineRange
+		valid    bool
+	}{
+		{"1:5", LineRange{Start: 1, End: 5}, true},
+		{"7:7", LineRange{Start: 7, End: 7}, true},
+		{"5:1", LineRange{}, false},
+		{"0:3", LineRange{}, false},
+		{"3", LineRange{}, false},
+		{"a:b", LineRange{}, false},
+	} {
+		r, err := ParseLineRange(test.s)
+		if (err == nil) != test.valid || r != test.expected {
+			t.Errorf("%q: expected %v (valid %t), got %v (%v)", test.s, test.expected, test.valid, r, err)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hugheaves/scadformat/internal/glob"
+)
+
+const ProjectConfigFileName = ".scadformat.toml"
+
+// FormatOptions are the formatting options that can be set in a project
+// configuration file. Options that are not set are nil.
+type FormatOptions struct {
+	IndentStyle            *string `toml:"indent_style"`             // "space" or "tab"
+	IndentSize             *int    `toml:"indent_size"`              // number of columns per indentation level
+	TabWidth               *int    `toml:"tab_width"`                // number of columns a tab is displayed as (default indent_size)
+	MaxLineLength          *int    `toml:"max_line_length"`          // maximum line length (0 for no limit)
+	EndOfLine              *string `toml:"end_of_line"`              // "lf", "crlf" or "cr"
+	InsertFinalNewline     *bool   `toml:"insert_final_newline"`     // end the file with a newline
+	TrimTrailingWhitespace *bool   `toml:"trim_trailing_whitespace"` // remove whitespace at the end of lines
+	ModuleBraceStyle       *string `toml:"module_brace_style"`       // brace placement for module definitions: "kr" or "allman"
+	ControlBraceStyle      *string `toml:"control_brace_style"`      // brace placement for if, else and for: "kr" or "allman"
+	ChildrenBraceStyle     *string `toml:"children_brace_style"`     // brace placement for the children of other modules: "kr" or "allman"
+	FunctionBodyStyle      *string `toml:"function_body_style"`      // function body placement: "break", "fit" or "break_complex"
+}
+
+// option values
+const (
+	IndentStyleSpace = "space"
+	IndentStyleTab   = "tab"
+	EndOfLineLF      = "lf"
+	EndOfLineCRLF    = "crlf"
+	EndOfLineCR      = "cr"
+	BraceStyleKR     = "kr"     // opening braces at the end of the line
+	BraceStyleAllman = "allman" // opening braces on a line of their own
+
+	FunctionBodyBreak        = "break"         // function bodies always start a new line
+	FunctionBodyFit          = "fit"           // function bodies are on the same line if they fit
+	FunctionBodyBreakComplex = "break_complex" // like fit, but ternary bodies start a new line
+)
+
+// validate checks that the options that are set have valid values.
+func (o *FormatOptions) validate() error {
+	if o.IndentStyle != nil && *o.IndentStyle != IndentStyleSpace && *o.IndentStyle != IndentStyleTab {
+		return fmt.Errorf("indent_style must be %q or %q", IndentStyleSpace, IndentStyleTab)
+	}
+	if o.EndOfLine != nil && *o.EndOfLine != EndOfLineLF && *o.EndOfLine != EndOfLineCRLF && *o.EndOfLine != EndOfLineCR {
+		return fmt.Errorf("end_of_line must be %q, %q or %q", EndOfLineLF, EndOfLineCRLF, EndOfLineCR)
+	}
+	braceStyles := []struct {
+		name  string
+		value *string
+	}{
+		{"module_brace_style", o.ModuleBraceStyle},
+		{"control_brace_style", o.ControlBraceStyle},
+		{"children_brace_style", o.ChildrenBraceStyle},
+	}
+	for _, style := range braceStyles {
+		if style.value != nil && *style.value != BraceStyleKR && *style.value != BraceStyleAllman {
+			return fmt.Errorf("%s must be %q or %q", style.name, BraceStyleKR, BraceStyleAllman)
+		}
+	}
+	if o.FunctionBodyStyle != nil && *o.FunctionBodyStyle != FunctionBodyBreak && *o.FunctionBodyStyle != FunctionBodyFit && *o.FunctionBodyStyle != FunctionBodyBreakComplex {
+		return fmt.Errorf("function_body_style must be %q, %q or %q", FunctionBodyBreak, FunctionBodyFit, FunctionBodyBreakComplex)
+	}
+	if o.IndentSize != nil && *o.IndentSize < 0 {
+		return fmt.Errorf("indent_size must not be negative")
+	}
+	if o.TabWidth != nil && *o.TabWidth < 1 {
+		return fmt.Errorf("tab_width must be at least 1")
+	}
+	if o.MaxLineLength != nil && *o.MaxLineLength < 0 {
+		return fmt.Errorf("max_line_length must not be negative")
+	}
+	return nil
+}
+
+// override is a section of a project configuration file that only applies to
+// files matching one of the glob patterns.
+type override struct {
+	Files []string `toml:"files"`
+	FormatOptions
+	globs []*glob.Glob
+}
+
+// projectConfigFile is the content of a .scadformat.toml file.
+type projectConfigFile struct {
+	Root bool `toml:"root"` // stop searching for configuration files in parent directories
+	FormatOptions
+	Overrides []*override `toml:"overrides"`
+	path      string
+}
+
+// ResolvedOptions are the effective formatting options for a file, along with the
+// source of each option.
+type ResolvedOptions struct {
+	FormatOptions
+	sources map[string]string
+}
+
+// Source returns a description of where the named option was set.
+func (r *ResolvedOptions) Source(name string) string {
+	return r.sources[name]
+}
+
+// merge sets each option that is set in options, recording the source.
+func (r *ResolvedOptions) merge(options *FormatOptions, source string) {
+	dst := reflect.ValueOf(&r.FormatOptions).Elem()
+	src := reflect.ValueOf(options).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if !src.Field(i).IsNil() {
+			dst.Field(i).Set(src.Field(i))
+			r.sources[optionName(src.Type().Field(i))] = source
+		}
+	}
+}
+
+// Dump writes the options in TOML format, with a comment showing where each
+// option was set. Options that are not set are commented out.
+func (r *ResolvedOptions) Dump(w io.Writer) error {
+	v := reflect.ValueOf(&r.FormatOptions).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name := optionName(v.Type().Field(i))
+		var err error
+		if v.Field(i).IsNil() {
+			_, err = fmt.Fprintf(w, "# %s is not set\n", name)
+		} else {
+			_, err = fmt.Fprintf(w, "%s = %#v  # %s\n", name, v.Field(i).Elem().Interface(), r.sources[name])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func optionName(field reflect.StructField) string {
+	return strings.Split(field.Tag.Get("toml"), ",")[0]
+}
+
+// ProjectConfigLoader finds and loads the project configuration files that
+// apply to source files. Configuration files are cached, so each file is only
+// loaded once.
+type ProjectConfigLoader struct {
+	mutex         sync.Mutex
+	files         map[string]*projectConfigFile // configuration file (or nil) for each directory
+	editorConfigs map[string]*editorConfigFile  // .editorconfig file (or nil) for each directory
+}
+
+func NewProjectConfigLoader() *ProjectConfigLoader {
+	return &ProjectConfigLoader{
+		files:         make(map[string]*projectConfigFile),
+		editorConfigs: make(map[string]*editorConfigFile),
+	}
+}
+
+// Resolve returns the formatting options for a source file. Starting with the
+// defaults, the options from each configuration file found in the file's
+// directory and its parent directories are applied, from the furthest to the
+// nearest, so that nearer files take precedence. The search stops at a
+// configuration file containing "root = true". Within a configuration file,
+// overrides are applied in order after the top level options.
+//
+// .editorconfig files are searched for in the same way, and are applied before
+// the .scadformat.toml files, so that .scadformat.toml options take precedence.
+func (l *ProjectConfigLoader) Resolve(fileName string, defaults FormatOptions) (*ResolvedOptions, error) {
+	absFileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var configFiles []*projectConfigFile
+	var editorConfigs []*editorConfigFile
+	searchConfig, searchEditorConfig := true, true
+	for dir := filepath.Dir(absFileName); searchConfig || searchEditorConfig; dir = filepath.Dir(dir) {
+		configFile, editorConfig, err := l.load(dir)
+		if err != nil {
+			return nil, err
+		}
+		if configFile != nil && searchConfig {
+			configFiles = append(configFiles, configFile)
+			searchConfig = !configFile.Root
+		}
+		if editorConfig != nil && searchEditorConfig {
+			editorConfigs = append(editorConfigs, editorConfig)
+			searchEditorConfig = !editorConfig.root
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	resolved := &ResolvedOptions{sources: make(map[string]string)}
+	resolved.merge(&defaults, "default")
+	properties, err := resolveEditorConfig(editorConfigs, absFileName)
+	if err != nil {
+		return nil, err
+	}
+	resolved.mergeEditorConfig(properties)
+	for i := len(configFiles) - 1; i >= 0; i-- {
+		configFile := configFiles[i]
+		resolved.merge(&configFile.FormatOptions, configFile.path)
+		relPath, err := filepath.Rel(filepath.Dir(configFile.path), absFileName)
+		if err != nil {
+			return nil, err
+		}
+		for _, override := range configFile.Overrides {
+			if pattern := override.match(filepath.ToSlash(relPath)); pattern != "" {
+				resolved.merge(&override.FormatOptions, fmt.Sprintf("%s [overrides %q]", configFile.path, pattern))
+			}
+		}
+	}
+	return resolved, nil
+}
+
+// match returns the first pattern of the override that matches the path, or ""
+// if none match. Patterns that do not contain a "/" are matched against the
+// file name only, so they apply in any directory.
+func (o *override) match(relPath string) string {
+	for _, g := range o.globs {
+		path := relPath
+		if !strings.Contains(g.String(), "/") {
+			path = filepath.Base(filepath.FromSlash(relPath))
+		}
+		if g.Match(path) {
+			return g.String()
+		}
+	}
+	return ""
+}
+
+// load returns the .scadformat.toml and .editorconfig files in a directory.
+// Either may be nil if the directory doesn't contain the file.
+func (l *ProjectConfigLoader) load(dir string) (*projectConfigFile, *editorConfigFile, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	configFile, ok := l.files[dir]
+	if !ok {
+		var err error
+		configFile, err = readProjectConfigFile(filepath.Join(dir, ProjectConfigFileName))
+		if err != nil {
+			return nil, nil, err
+		}
+		l.files[dir] = configFile
+	}
+
+	editorConfig, ok := l.editorConfigs[dir]
+	if !ok {
+		var err error
+		editorConfig, err = readEditorConfigFile(filepath.Join(dir, EditorConfigFileName))
+		if err != nil {
+			return nil, nil, err
+		}
+		l.editorConfigs[dir] = editorConfig
+	}
+	return configFile, editorConfig, nil
+}
+
+func readProjectConfigFile(path string) (*projectConfigFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	configFile := &projectConfigFile{path: path}
+	metaData, err := toml.Decode(string(data), configFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if undecoded := metaData.Undecoded(); len(undecoded) > 0 {
+		return nil, fmt.Errorf("%s: unknown option %q", path, undecoded[0].String())
+	}
+
+	err = configFile.validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, override := range configFile.Overrides {
+		if len(override.Files) == 0 {
+			return nil, fmt.Errorf("%s: overrides must specify at least one pattern in \"files\"", path)
+		}
+		for _, pattern := range override.Files {
+			g, err := glob.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			override.globs = append(override.globs, g)
+		}
+		err = override.validate()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return configFile, nil
+}
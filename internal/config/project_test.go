@@ -0,0 +1,134 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func writeConfigFile(t *testing.T, dir string, content string) string {
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, ProjectConfigFileName)
+	err = os.WriteFile(path, []byte(content), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func intOption(value int) *int {
+	return &value
+}
+
+func TestResolve(t *testing.T) {
+	root := t.TempDir()
+	writeConfigFile(t, filepath.Join(root, "outer"), "indent_size = 8\nmax_line_length = 80\n")
+	rootConfig := writeConfigFile(t, filepath.Join(root, "outer", "project"), `
+root = true
+indent_size = 4
+
+[[overrides]]
+files = ["lib/**"]
+max_line_length = 120
+
+[[overrides]]
+files = ["*.inc.scad"]
+indent_size = 3
+`)
+	nearConfig := writeConfigFile(t, filepath.Join(root, "outer", "project", "lib", "sub"), "indent_size = 2\n")
+
+	defaults := FormatOptions{IndentSize: intOption(2), MaxLineLength: intOption(0)}
+	loader := NewProjectConfigLoader()
+
+	tests := []struct {
+		file          string
+		indentSize    int
+		indentSource  string
+		maxLineLength int
+		maxLineSource string
+	}{
+		{"outer/project/main.scad", 4, rootConfig, 0, "default"},
+		{"outer/project/lib/sub/part.scad", 2, nearConfig, 120, rootConfig + ` [overrides "lib/**"]`},
+		{"outer/project/other/part.inc.scad", 3, rootConfig + ` [overrides "*.inc.scad"]`, 0, "default"},
+	}
+	for _, test := range tests {
+		resolved, err := loader.Resolve(filepath.Join(root, filepath.FromSlash(test.file)), defaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *resolved.IndentSize != test.indentSize || resolved.Source("indent_size") != test.indentSource {
+			t.Errorf("%s: expected indent_size %d from %s, got %d from %s", test.file,
+				test.indentSize, test.indentSource, *resolved.IndentSize, resolved.Source("indent_size"))
+		}
+		if *resolved.MaxLineLength != test.maxLineLength || resolved.Source("max_line_length") != test.maxLineSource {
+			t.Errorf("%s: expected max_line_length %d from %s, got %d from %s", test.file,
+				test.maxLineLength, test.maxLineSource, *resolved.MaxLineLength, resolved.Source("max_line_length"))
+		}
+	}
+}
+
+func TestResolveInvalid(t *testing.T) {
+	root := t.TempDir()
+	defaults := FormatOptions{IndentSize: intOption(2), MaxLineLength: intOption(0)}
+
+	for _, content := range []string{"unknown_option = 1\n", "indent_size = -1\n", "indent_size = \"two\"\n", "[[overrides]]\nindent_size = 1\n"} {
+		writeConfigFile(t, root, content)
+		_, err := NewProjectConfigLoader().Resolve(filepath.Join(root, "part.scad"), defaults)
+		if err == nil {
+			t.Errorf("expected error for config file content %q", content)
+		}
+	}
+}
+
+// Test that the dumped options can be loaded again, including options that
+// are not set
+func TestDumpRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	writeConfigFile(t, root, "indent_style = \"tab\"\n")
+	defaults := FormatOptions{IndentSize: intOption(2), MaxLineLength: intOption(0)}
+	resolved, err := NewProjectConfigLoader().Resolve(filepath.Join(root, "part.scad"), defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = resolved.Dump(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded FormatOptions
+	_, err = toml.Decode(buf.String(), &loaded)
+	if err != nil {
+		t.Fatalf("dumped options are not valid TOML: %s\n%s", err, buf.String())
+	}
+	if loaded.TabWidth != nil {
+		t.Errorf("expected tab_width to be unset, got %d", *loaded.TabWidth)
+	}
+	if *loaded.IndentStyle != IndentStyleTab || *loaded.IndentSize != 2 {
+		t.Errorf("unexpected indent_style %s, indent_size %d", *loaded.IndentStyle, *loaded.IndentSize)
+	}
+}
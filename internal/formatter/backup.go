@@ -0,0 +1,243 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	backupTimestampLayout       = "2006-01-02_15-04-05.000000000"
+	legacyBackupTimestampLayout = "2006-01-02_15-04-05" // backups written before timestamps had sub-second precision
+)
+
+// backupPrefix returns the path of a file's backups, up to (but not including)
+// the timestamp. Backups are written next to the source file, unless a backup
+// directory is configured. In that case, the backup directory mirrors the tree
+// below the directory that contains it, so that backups of files with the same
+// name in different directories do not collide. Files outside of that tree are
+// mirrored using their absolute path.
+func (f *Formatter) backupPrefix(fileName string) (string, error) {
+	base := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName)) + "_"
+	if f.config.BackupDir == "" {
+		return filepath.Join(filepath.Dir(fileName), base), nil
+	}
+
+	backupDir, err := filepath.Abs(f.config.BackupDir)
+	if err != nil {
+		return "", err
+	}
+	absFileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return "", err
+	}
+	relDir, err := filepath.Rel(filepath.Dir(backupDir), filepath.Dir(absFileName))
+	if err != nil || relDir == ".." || strings.HasPrefix(relDir, ".."+string(filepath.Separator)) {
+		relDir = strings.TrimPrefix(filepath.Dir(absFileName), filepath.VolumeName(absFileName))
+	}
+	return filepath.Join(backupDir, relDir, base), nil
+}
+
+// backupFile saves the original content of a file before it is overwritten,
+// and then removes any backups beyond the configured number to keep.
+func (f *Formatter) backupFile(fileName string, content []byte) error {
+	if f.config.NoBackups {
+		return nil
+	}
+
+	prefix, err := f.backupPrefix(fileName)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(prefix), 0777)
+	if err != nil {
+		return err
+	}
+	backupFileName, err := writeBackup(prefix, content)
+	if err != nil {
+		return err
+	}
+	zap.S().Debugf("backed up %s to %s", fileName, backupFileName)
+
+	return f.pruneBackups(fileName)
+}
+
+// writeBackup writes a new backup, named with the prefix and the current time,
+// and returns its name. Existing backups are never overwritten: if a backup
+// with the same timestamp exists, the timestamp is moved on until it is unique.
+func writeBackup(prefix string, content []byte) (string, error) {
+	for timestamp := time.Now(); ; timestamp = timestamp.Add(time.Nanosecond) {
+		backupFileName := prefix + timestamp.Format(backupTimestampLayout) + backupFileExt
+		file, err := os.OpenFile(backupFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		if err == nil {
+			_, err = file.Write(content)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to write file %s: %w", backupFileName, err)
+		}
+		return backupFileName, nil
+	}
+}
+
+// parseBackupTimestamp returns the time that a backup was written, from the
+// timestamp in its name.
+func parseBackupTimestamp(timestamp string) (time.Time, error) {
+	t, err := time.Parse(backupTimestampLayout, timestamp)
+	if err != nil {
+		t, err = time.Parse(legacyBackupTimestampLayout, timestamp)
+	}
+	return t, err
+}
+
+// findBackups returns the backups of a file, oldest first.
+func (f *Formatter) findBackups(fileName string) ([]string, error) {
+	prefix, err := f.backupPrefix(fileName)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Dir(prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(prefix)
+	var backups []string
+	times := make(map[string]time.Time)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base) || !strings.HasSuffix(name, backupFileExt) {
+			continue
+		}
+		// check the timestamp, so that backups of "part.scad" don't include
+		// backups of "part_2.scad"
+		timeStamp := strings.TrimSuffix(strings.TrimPrefix(name, base), backupFileExt)
+		t, err := parseBackupTimestamp(timeStamp)
+		if err != nil {
+			continue
+		}
+		backup := filepath.Join(filepath.Dir(prefix), name)
+		backups = append(backups, backup)
+		times[backup] = t
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return times[backups[i]].Before(times[backups[j]])
+	})
+	return backups, nil
+}
+
+// pruneBackups removes the oldest backups of a file, keeping only the
+// configured number of backups. A count of zero keeps all backups.
+func (f *Formatter) pruneBackups(fileName string) error {
+	if f.config.BackupCount <= 0 {
+		return nil
+	}
+	backups, err := f.findBackups(fileName)
+	if err != nil {
+		return err
+	}
+	for len(backups) > f.config.BackupCount {
+		zap.S().Debugf("removing old backup %s", backups[0])
+		err = os.Remove(backups[0])
+		if err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// Restore replaces each .scad file in the configured target paths with its most
+// recent backup. Backups are left in place, so restoring is repeatable. Files
+// without backups are skipped.
+func (f *Formatter) Restore() error {
+	if len(f.config.TargetPaths) == 0 {
+		return errors.New("restore requires at least one file or directory")
+	}
+
+	failed := 0
+	restored := 0
+	for _, file := range findSourceFiles(f.config.TargetPaths, f.config.Recurse, f.ignore) {
+		if file.ignored {
+			continue
+		}
+		err := file.err
+		// a file that no longer exists can still be restored from a backup
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			var backupFileName string
+			backupFileName, err = f.restoreFile(file.path)
+			if err == nil && backupFileName == "" {
+				zap.S().Warnf("%s: no backup found", file.path)
+				continue
+			}
+			if err == nil {
+				zap.S().Infof("%s: restored from %s", file.path, backupFileName)
+				restored++
+				continue
+			}
+		}
+		zap.S().Errorf("%s: %s", file.path, err)
+		failed++
+	}
+
+	zap.S().Infof("%d files restored", restored)
+	if failed > 0 {
+		return fmt.Errorf("failed to restore %d files", failed)
+	}
+	return nil
+}
+
+// restoreFile restores a file from its most recent backup, returning the name
+// of the backup, or "" if the file has no backups.
+func (f *Formatter) restoreFile(fileName string) (string, error) {
+	backups, err := f.findBackups(fileName)
+	if err != nil || len(backups) == 0 {
+		return "", err
+	}
+	backupFileName := backups[len(backups)-1]
+	content, err := os.ReadFile(backupFileName)
+	if err != nil {
+		return "", err
+	}
+	if _, statErr := os.Stat(fileName); statErr == nil {
+		err = writeFile(fileName, content, false)
+	} else {
+		err = os.WriteFile(fileName, content, 0666)
+	}
+	if err != nil {
+		return "", err
+	}
+	return backupFileName, nil
+}
@@ -0,0 +1,125 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugheaves/scadformat/internal/config"
+)
+
+func TestBackupRotateAndRestore(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, ".backups")
+	original := "x=1;\n"
+	writeTree(t, root, map[string]string{
+		"src/part.scad": original,
+		".backups/src/part_2020-01-01_00-00-00.scadbak":   "old 1",
+		".backups/src/part_2020-01-02_00-00-00.scadbak":   "old 2",
+		".backups/src/part_2_2020-01-03_00-00-00.scadbak": "other file",
+	})
+	fileName := filepath.Join(root, "src", "part.scad")
+
+	mainConfig := &config.MainConfig{
+		BackupDir:   backupDir,
+		BackupCount: 2,
+		TargetPaths: []string{fileName},
+	}
+	f := NewFormatter(mainConfig)
+	summary, err := f.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count(StatusReformatted) != 1 {
+		t.Fatalf("expected file to be reformatted: %s", summary)
+	}
+
+	backups, err := f.findBackups(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || filepath.Base(backups[0]) != "part_2020-01-02_00-00-00.scadbak" {
+		t.Fatalf("unexpected backups after rotation: %v", backups)
+	}
+	if _, err := os.Stat(filepath.Join(backupDir, "src", "part_2_2020-01-03_00-00-00.scadbak")); err != nil {
+		t.Fatal("backup of a different file was removed")
+	}
+
+	err = f.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != original {
+		t.Fatalf("expected restored content %q, got %q", original, content)
+	}
+}
+
+func TestBackupsInQuickSuccession(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"part.scad": "x=1;\n"})
+	fileName := filepath.Join(root, "part.scad")
+	f := NewFormatter(&config.MainConfig{BackupDir: filepath.Join(root, ".backups"), BackupCount: 5})
+
+	for _, content := range []string{"first", "second"} {
+		if err := f.backupFile(fileName, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := f.findBackups(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 backups, got %v", backups)
+	}
+
+	if _, err := f.restoreFile(fileName); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "second" {
+		t.Fatalf("expected the latest backup to be restored, got %q", content)
+	}
+}
+
+func TestNoBackups(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"part.scad": "x=1;\n"})
+
+	_, err := NewFormatter(&config.MainConfig{NoBackups: true, TargetPaths: []string{root}}).Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected no backup files, found %d files", len(entries))
+	}
+}
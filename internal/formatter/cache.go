@@ -0,0 +1,59 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"github.com/hugheaves/scadformat/internal/cache"
+	"go.uber.org/zap"
+)
+
+// cacheKey returns the key used to record that the source code of a file is
+// formatted according to the settings.
+func (f *Formatter) cacheKey(input []byte, settings *FormatSettings) string {
+	return cache.Key(f.buildHash, settings.fingerprint(), input)
+}
+
+// isFormatted returns true if the cache records that source code is already
+// formatted, so that it doesn't need to be parsed.
+func (f *Formatter) isFormatted(key string) bool {
+	return f.cache != nil && f.cache.Has(key)
+}
+
+// addFormatted records in the cache that source code is already formatted.
+// Failing to update the cache doesn't affect the result of formatting.
+func (f *Formatter) addFormatted(key string) {
+	if f.cache == nil {
+		return
+	}
+	err := f.cache.Add(key)
+	if err != nil {
+		zap.S().Warn(err)
+	}
+}
+
+// pruneCache removes old entries from the cache, if it is due to be pruned.
+func (f *Formatter) pruneCache() {
+	if f.cache == nil {
+		return
+	}
+	err := f.cache.Prune()
+	if err != nil {
+		zap.S().Warn(err)
+	}
+}
@@ -0,0 +1,79 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hugheaves/scadformat/internal/config"
+)
+
+func TestCachedFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".git/HEAD":        "",
+		"formatted.scad":   "x = 1;\n",
+		"unformatted.scad": "x=1;\n",
+	})
+	mainConfig := &config.MainConfig{Check: true, CacheDir: filepath.Join(t.TempDir(), "cache"), TargetPaths: []string{root}}
+	f := NewFormatter(mainConfig)
+	settings, err := f.settingsFor(filepath.Join(root, "formatted.scad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := f.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count(StatusUnchanged) != 1 || summary.Count(StatusWouldReformat) != 1 {
+		t.Fatalf("unexpected summary: %s", summary)
+	}
+	if !f.isFormatted(f.cacheKey([]byte("x = 1;\n"), settings)) {
+		t.Error("expected formatted file to be cached")
+	}
+	if f.isFormatted(f.cacheKey([]byte("x=1;\n"), settings)) {
+		t.Error("unformatted file should not be cached")
+	}
+
+	// a cache entry skips formatting, even if the content isn't formatted
+	f.addFormatted(f.cacheKey([]byte("x=1;\n"), settings))
+	summary, err = f.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count(StatusUnchanged) != 2 {
+		t.Errorf("expected cached file to be skipped: %s", summary)
+	}
+
+	// the cache entry doesn't apply with different settings
+	err = os.WriteFile(filepath.Join(root, config.ProjectConfigFileName), []byte("indent_size = 4\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary, err = NewFormatter(mainConfig).Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count(StatusWouldReformat) != 1 {
+		t.Errorf("expected cache miss with different settings: %s", summary)
+	}
+}
@@ -0,0 +1,166 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hugheaves/scadformat/internal/config"
+	"github.com/hugheaves/scadformat/internal/gitdiff"
+	"go.uber.org/zap"
+)
+
+// formatChangedOnly returns true if only the lines that git reports as changed
+// should be formatted.
+func (f *Formatter) formatChangedOnly() bool {
+	return f.config.Since != "" || f.config.Staged
+}
+
+// findChangedFiles returns the source files with lines that have changed since
+// the configured revision (or that are staged), and records the changed lines
+// so that only the statements containing them are formatted. If target paths
+// are configured, only the changed files found in them are returned. Otherwise,
+// all of the changed files in the repository containing the current directory
+// are returned.
+func (f *Formatter) findChangedFiles() ([]sourceFile, error) {
+	// the target paths may be in different repositories
+	dirs := []string{"."}
+	if len(f.config.TargetPaths) > 0 {
+		dirs = nil
+		for _, targetPath := range f.config.TargetPaths {
+			if info, err := os.Stat(targetPath); err == nil && !info.IsDir() {
+				targetPath = filepath.Dir(targetPath)
+			}
+			dirs = append(dirs, targetPath)
+		}
+	}
+	changed := make(map[string][]config.LineRange)
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			// reported when the target path is formatted
+			continue
+		}
+		lines, err := gitdiff.ChangedLines(dir, f.config.Since, f.config.Staged)
+		if err != nil {
+			return nil, err
+		}
+		root, err := gitdiff.Toplevel(dir)
+		if err != nil {
+			return nil, err
+		}
+		for path := range lines {
+			f.addRepoRoot(path, root)
+		}
+		maps.Copy(changed, lines)
+	}
+	f.changedLines = make(map[string][]config.LineRange)
+	for path, lines := range changed {
+		f.changedLines[canonicalPath(path)] = lines
+	}
+
+	var files []sourceFile
+	if len(f.config.TargetPaths) > 0 {
+		for _, file := range findSourceFiles(f.config.TargetPaths, f.config.Recurse, f.ignore) {
+			if file.ignored {
+				continue
+			}
+			if file.err == nil && f.changedLines[canonicalPath(file.path)] == nil {
+				zap.S().Debugf("skipping %s: no changes", file.path)
+				continue
+			}
+			files = append(files, file)
+		}
+		return files, nil
+	}
+
+	for path := range changed {
+		if !isSourceFile(path) {
+			continue
+		}
+		rule, err := f.ignore.Match(path, false)
+		if err != nil {
+			files = append(files, sourceFile{path: path, err: err})
+			continue
+		}
+		if rule != nil {
+			zap.S().Debugf("skipping %s: ignored by %s", path, rule)
+			continue
+		}
+		files = append(files, sourceFile{path: relativePath(path)})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+	return files, nil
+}
+
+// changedLinesFor returns the lines of a file to format, when only formatting
+// changed lines.
+func (f *Formatter) changedLinesFor(fileName string) []config.LineRange {
+	return f.changedLines[canonicalPath(fileName)]
+}
+
+// addRepoRoot records the root of the repository containing a changed or
+// staged file.
+func (f *Formatter) addRepoRoot(path string, root string) {
+	if f.repoRoots == nil {
+		f.repoRoots = make(map[string]string)
+	}
+	f.repoRoots[canonicalPath(path)] = root
+}
+
+// diffBase returns the directory that paths in the diff of a file are relative
+// to. Like git, diffs of changed or staged files use paths relative to the root
+// of their repository. Otherwise, paths are relative to the current directory.
+func (f *Formatter) diffBase(fileName string) string {
+	if f.repoRoots == nil {
+		return ""
+	}
+	return f.repoRoots[canonicalPath(fileName)]
+}
+
+// relativePath returns a path relative to the current directory, for use in
+// messages and diffs. If that isn't possible, the path is returned unchanged.
+func relativePath(path string) string {
+	cwd, err := filepath.Abs(".")
+	if err != nil {
+		return path
+	}
+	relPath, err := filepath.Rel(canonicalPath(cwd), canonicalPath(path))
+	if err != nil {
+		return path
+	}
+	return relPath
+}
+
+// canonicalPath returns the absolute path of a file with symbolic links
+// resolved, so that paths reported by git can be compared with target paths.
+func canonicalPath(path string) string {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+		return resolved
+	}
+	return absPath
+}
@@ -0,0 +1,174 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hugheaves/scadformat/internal/config"
+)
+
+// initRepo creates a git repository, and returns its root and a function that
+// runs git commands in it.
+func initRepo(t *testing.T) (string, func(args ...string) string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	root := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = root
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s", args, output)
+		}
+		return string(output)
+	}
+	git("init", "-q")
+	return root, git
+}
+
+func TestFormatChanged(t *testing.T) {
+	root, git := initRepo(t)
+	writeTree(t, root, map[string]string{
+		"a.scad": "module a(){\ncube(1);\n}\nmodule b(){\ncube(2);\n}\n",
+		"b.scad": "x=1;\n",
+	})
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	writeTree(t, root, map[string]string{"a.scad": "module a(){\ncube(1);\n}\nmodule b(){\ncube(3);\n}\n"})
+
+	f := NewFormatter(&config.MainConfig{Since: "HEAD", TargetPaths: []string{root}, NoBackups: true, Jobs: 1})
+	summary, err := f.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Total() != 1 || summary.Count(StatusReformatted) != 1 {
+		t.Errorf("expected one reformatted file, got %s", summary)
+	}
+	for name, expected := range map[string]string{
+		"a.scad": "module a(){\ncube(1);\n}\nmodule b() {\n  cube(3);\n}\n",
+		"b.scad": "x=1;\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, string(content))
+		}
+	}
+}
+
+func TestFormatChangedDiffPaths(t *testing.T) {
+	root, git := initRepo(t)
+	writeTree(t, root, map[string]string{"a.scad": "x=1;\n", "sub/b.scad": "y=1;\n"})
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	writeTree(t, root, map[string]string{"a.scad": "x=2;\n"})
+
+	// diffs use paths relative to the root of the repository, like git
+	t.Chdir(filepath.Join(root, "sub"))
+	var stdout strings.Builder
+	f := NewFormatter(&config.MainConfig{Since: "HEAD", Diff: true, Jobs: 1})
+	f.stdout = &stdout
+	_, err := f.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "--- a/a.scad\n+++ b/a.scad\n") {
+		t.Errorf("expected paths relative to the repository root:\n%s", stdout.String())
+	}
+}
+
+func TestFormatStaged(t *testing.T) {
+	root, git := initRepo(t)
+	writeTree(t, root, map[string]string{"a.scad": "x=1;\n", "b.scad": "y=1;\n"})
+	git("add", ".")
+	// a.scad has an unstaged change, which must not be staged or formatted,
+	// and prevents its staged content from being formatted in fix mode
+	writeTree(t, root, map[string]string{"a.scad": "x=1;\nz=2;\n"})
+
+	t.Chdir(root)
+	var stdout strings.Builder
+	f := NewFormatter(&config.MainConfig{HookMode: HookModeCheck})
+	f.stdout = &stdout
+	summary, err := f.FormatStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count(StatusWouldReformat) != 2 || !strings.Contains(stdout.String(), "+x = 1;") {
+		t.Errorf("expected diffs for two files, got %s:\n%s", summary, stdout.String())
+	}
+
+	stdout.Reset()
+	f = NewFormatter(&config.MainConfig{HookMode: HookModeCheck, JSON: true})
+	f.stdout = &stdout
+	_, err = f.FormatStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), `"would-reformat": 2`) || !strings.Contains(stdout.String(), `"diff": "diff --git a/a.scad b/a.scad`) {
+		t.Errorf("expected a JSON report, got:\n%s", stdout.String())
+	}
+
+	f = NewFormatter(&config.MainConfig{HookMode: HookModeFix})
+	summary, err = f.FormatStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count(StatusReformatted) != 1 || summary.Count(StatusIOError) != 1 {
+		t.Errorf("expected one reformatted file and one error, got %s", summary)
+	}
+	for name, expected := range map[string][2]string{
+		"a.scad": {"x=1;\n", "x=1;\nz=2;\n"},
+		"b.scad": {"y = 1;\n", "y = 1;\n"},
+	} {
+		if staged := git("show", ":"+name); staged != expected[0] {
+			t.Errorf("%s: expected staged %q, got %q", name, expected[0], staged)
+		}
+		content, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected[1] {
+			t.Errorf("%s: expected working tree %q, got %q", name, expected[1], string(content))
+		}
+	}
+}
+
+func TestInstallHook(t *testing.T) {
+	root, _ := initRepo(t)
+	t.Chdir(root)
+	err := NewFormatter(&config.MainConfig{HookMode: HookModeCheck}).InstallHook()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := os.ReadFile(filepath.Join(root, ".git", "hooks", "pre-commit"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(script), "\nexec scadformat hook run --hook-mode check\n") {
+		t.Errorf("expected the hook to run scadformat from the PATH:\n%s", script)
+	}
+}
@@ -0,0 +1,65 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+)
+
+// sourceCursor is the position of the cursor in the source code, which is
+// tracked so that the matching position in the output can be found.
+type sourceCursor struct {
+	offset      int   // byte offset of the cursor in the source code
+	byteOffsets []int // byte offset of each character, as token positions are character (rune) indexes
+}
+
+func newSourceCursor(input []byte, offset int) *sourceCursor {
+	// invalid UTF-8 bytes are read as a character each, both here and by the lexer
+	byteOffsets := make([]int, 0, len(input)+1)
+	for i := range string(input) {
+		byteOffsets = append(byteOffsets, i)
+	}
+	byteOffsets = append(byteOffsets, len(input))
+	return &sourceCursor{offset: min(max(offset, 0), len(input)), byteOffsets: byteOffsets}
+}
+
+// byteOffset returns the byte offset of a character in the source code.
+func (c *sourceCursor) byteOffset(charIndex int) int {
+	return c.byteOffsets[min(charIndex, len(c.byteOffsets)-1)]
+}
+
+// trackCursor is called before the text of a token is printed. If the token
+// contains or precedes the cursor, the document marks where the cursor is
+// within the text that is printed next, so that the cursor moves with the token.
+// A cursor in the space after a token is placed at the end of the token. The
+// printed text is the token text with leading whitespace removed, and is at
+// most length bytes long.
+func (v *FormattingVisitor) trackCursor(token antlr.Token, length int) {
+	if v.cursor == nil {
+		return
+	}
+	text := token.GetText()
+	start := v.cursor.byteOffset(token.GetStart()) + len(text) - len(strings.TrimLeft(text, " \t\r\n"))
+	if start > v.cursor.offset {
+		return
+	}
+	v.doc.markCursor(min(v.cursor.offset-start, length))
+}
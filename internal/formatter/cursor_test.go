@@ -0,0 +1,69 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hugheaves/scadformat/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestFormatCursor(t *testing.T) {
+	tests := []struct {
+		input    string
+		cursor   string // the cursor is before the first occurrence of this text in the input
+		expected string // the formatted output, with | marking the expected cursor position
+		lines    []config.LineRange
+	}{
+		{"module a(){cube(1);}\n", "(1)", "module a() {\n  cube|(1);\n}\n", nil},
+		{"x=1;   \n\n\ny=2;\n", "   ", "x = 1;|\n\n\ny = 2;\n", nil},
+		{"  x=1;\n", "  x", "|x = 1;\n", nil},
+		{"x=1; // comment\n", "ment", "x = 1; // com|ment\n", nil},
+		{"s=\"ü\";x=1;\n", "x=", "s = \"ü\";\n|x = 1;\n", nil},
+		{"x=1;\n", "\n", "x = 1;|\n", nil},
+		{"x=1;\nmodule a(){cube(1);}\n", "cube", "x = 1;\nmodule a(){|cube(1);}\n", []config.LineRange{{Start: 1, End: 1}}},
+		{"x=1;\nmodule a(){cube(1);}\n", "cube", "x=1;\nmodule a() {\n  |cube(1);\n}\n", []config.LineRange{{Start: 2, End: 2}}},
+	}
+	for _, test := range tests {
+		output, cursor, err := FormatSource([]byte(test.input), &config.FormatOptions{}, test.lines, strings.Index(test.input, test.cursor), zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if marked := string(output[:cursor]) + "|" + string(output[cursor:]); marked != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.input, test.expected, marked)
+		}
+	}
+}
+
+func TestStdinCursorOffset(t *testing.T) {
+	cursorOffset := 2
+	var stdout strings.Builder
+	f := NewFormatter(&config.MainConfig{CursorOffset: &cursorOffset})
+	f.stdin = strings.NewReader("x=1;\n")
+	f.stdout = &stdout
+	_, err := f.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "{\"cursor\": 4}\nx = 1;\n"; stdout.String() != expected {
+		t.Errorf("expected %q, got %q", expected, stdout.String())
+	}
+}
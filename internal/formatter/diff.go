@@ -0,0 +1,179 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hexops/gotextdiff"
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+)
+
+// ANSI escape sequences used to color diff output
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorCyan   = "\x1b[36m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	noColorName = "NO_COLOR"
+)
+
+// diffWriter writes unified diffs in the format produced by "git diff", so
+// that the output can be applied with "git apply" or "patch -p1".
+type diffWriter struct {
+	writer io.Writer
+	color  bool
+	base   string // directory that paths in diff headers are relative to ("" for the current directory)
+}
+
+// useColor returns true if diffs written to the file should be colored.
+func useColor(file *os.File) bool {
+	return isTerminal(file) && os.Getenv(noColorName) == ""
+}
+
+// isTerminal returns true if the file is a character device (i.e. a terminal).
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// writeDiff writes the differences between input and output for the named
+// file. Nothing is written if input and output are identical.
+func (d *diffWriter) writeDiff(fileName string, input []byte, output []byte) error {
+	from, to := d.headerPaths(fileName)
+	edits := myers.ComputeEdits(span.URIFromPath(filepath.ToSlash(fileName)), string(input), string(output))
+	unified := gotextdiff.ToUnified(from, to, string(input), edits)
+	if len(unified.Hunks) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	d.writeLine(&sb, colorBold, fmt.Sprintf("diff --git %s %s\n", from, to))
+	d.writeLine(&sb, colorBold, fmt.Sprintf("--- %s\n", unified.From))
+	d.writeLine(&sb, colorBold, fmt.Sprintf("+++ %s\n", unified.To))
+	for _, hunk := range unified.Hunks {
+		fromCount, toCount := 0, 0
+		for _, line := range hunk.Lines {
+			switch line.Kind {
+			case gotextdiff.Delete:
+				fromCount++
+			case gotextdiff.Insert:
+				toCount++
+			default:
+				fromCount++
+				toCount++
+			}
+		}
+		header := fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(hunk.FromLine, fromCount), hunkRange(hunk.ToLine, toCount))
+		d.writeLine(&sb, colorCyan, header)
+		for _, line := range hunk.Lines {
+			switch line.Kind {
+			case gotextdiff.Delete:
+				d.writeLine(&sb, colorRed, "-"+line.Content)
+			case gotextdiff.Insert:
+				d.writeLine(&sb, colorGreen, "+"+line.Content)
+			default:
+				d.writeLine(&sb, "", " "+line.Content)
+			}
+			if !strings.HasSuffix(line.Content, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+
+	_, err := io.WriteString(d.writer, sb.String())
+	return err
+}
+
+// headerPaths returns the old and new paths of a file in the header of its
+// diff. Paths within the base directory are made relative to it, and given the
+// "a/" and "b/" prefixes that "git apply" and "patch -p1" remove. Paths outside
+// the base directory can't be given prefixes that would be removed correctly,
+// so are used as they are.
+func (d *diffWriter) headerPaths(fileName string) (string, string) {
+	path := filepath.Clean(fileName)
+	if base, err := filepath.Abs(d.base); err == nil && (filepath.IsAbs(path) || d.base != "") {
+		if absPath, err := filepath.Abs(path); err == nil {
+			if relPath, err := filepath.Rel(canonicalPath(base), canonicalPath(absPath)); err == nil {
+				path = relPath
+			}
+		}
+	}
+	if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		path = filepath.ToSlash(fileName)
+		return path, path
+	}
+	path = filepath.ToSlash(path)
+	return "a/" + path, "b/" + path
+}
+
+// writeLine appends a line of diff output, wrapped in the given color if color
+// output is enabled. The trailing newline (if any) is kept outside of the color
+// sequence.
+func (d *diffWriter) writeLine(sb *strings.Builder, color string, line string) {
+	if !d.color || color == "" {
+		sb.WriteString(line)
+		return
+	}
+	text, newLine := strings.CutSuffix(line, "\n")
+	sb.WriteString(color + text + colorReset)
+	if newLine {
+		sb.WriteString("\n")
+	}
+}
+
+// hunkRange formats the line range of a hunk header. By convention, an empty
+// range refers to the line before the hunk.
+func hunkRange(start int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	default:
+		return fmt.Sprintf("%d,%d", start, count)
+	}
+}
+
+// countChangedLines returns the number of lines added and removed by changing
+// input to output.
+func countChangedLines(input []byte, output []byte) (added int, removed int) {
+	edits := myers.ComputeEdits("", string(input), string(output))
+	unified := gotextdiff.ToUnified("", "", string(input), edits)
+	for _, hunk := range unified.Hunks {
+		for _, line := range hunk.Lines {
+			switch line.Kind {
+			case gotextdiff.Insert:
+				added++
+			case gotextdiff.Delete:
+				removed++
+			}
+		}
+	}
+	return added, removed
+}
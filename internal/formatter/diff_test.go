@@ -0,0 +1,100 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteDiff(t *testing.T) {
+	var sb strings.Builder
+	d := &diffWriter{writer: &sb}
+
+	err := d.writeDiff("dir/file.scad", []byte("x=1;\ny = 2;\n"), []byte("x = 1;\ny = 2;\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "diff --git a/dir/file.scad b/dir/file.scad\n" +
+		"--- a/dir/file.scad\n" +
+		"+++ b/dir/file.scad\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		"-x=1;\n" +
+		"+x = 1;\n" +
+		" y = 2;\n"
+	if sb.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, sb.String())
+	}
+}
+
+func TestWriteDiffEmptyInput(t *testing.T) {
+	var sb strings.Builder
+	d := &diffWriter{writer: &sb}
+
+	err := d.writeDiff("file.scad", []byte(""), []byte("x = 1;\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "@@ -0,0 +1 @@\n") {
+		t.Fatalf("unexpected hunk header in:\n%s", sb.String())
+	}
+}
+
+func TestWriteDiffUnchanged(t *testing.T) {
+	var sb strings.Builder
+	d := &diffWriter{writer: &sb}
+
+	err := d.writeDiff("file.scad", []byte("x = 1;\n"), []byte("x = 1;\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sb.Len() != 0 {
+		t.Fatalf("expected no output, got:\n%s", sb.String())
+	}
+}
+
+func TestWriteDiffPaths(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "x.scad")
+	tests := []struct {
+		fileName string
+		expected string
+	}{
+		{filepath.Join(cwd, "dir", "file.scad"), "--- a/dir/file.scad\n+++ b/dir/file.scad\n"},
+		{"dir/../file.scad", "--- a/file.scad\n+++ b/file.scad\n"},
+		{outside, "--- " + filepath.ToSlash(outside) + "\n+++ " + filepath.ToSlash(outside) + "\n"},
+		{"../x.scad", "--- ../x.scad\n+++ ../x.scad\n"},
+	}
+	for _, test := range tests {
+		var sb strings.Builder
+		d := &diffWriter{writer: &sb}
+		err := d.writeDiff(test.fileName, []byte("x=1;\n"), []byte("x = 1;\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(sb.String(), test.expected) {
+			t.Errorf("%s: expected headers:\n%s\ngot:\n%s", test.fileName, test.expected, sb.String())
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import "strings"
+
+// The formatting visitor doesn't print the output directly. Instead, it builds
+// a document, which describes the layout of the formatted code in the style of
+// Wadler's "prettier printer": text, line breaks and indentation, and groups of
+// content that are printed on a single line if they fit, or are otherwise
+// broken over several lines. The printer then lays out the document, deciding
+// which groups to break.
+
+// doc is a document, or a part of one.
+type doc any
+
+type (
+	// docText is text that is appended to the line, and that is indented if it
+	// starts the line. It never contains line breaks.
+	docText string
+
+	// docRawText is text that is appended to the line without indentation.
+	docRawText string
+
+	// docConcat is a sequence of documents, printed one after the other.
+	docConcat []doc
+
+	// docGroup is printed without breaking its lines if it fits on the line,
+	// unless shouldBreak is true.
+	docGroup struct {
+		contents    doc
+		shouldBreak bool
+	}
+
+	// docIndent indents the lines started by its contents by one level.
+	docIndent struct {
+		contents doc
+	}
+
+	// docIfBreak prints broken if the enclosing group is broken, and otherwise
+	// prints flat.
+	docIfBreak struct {
+		broken doc
+		flat   doc
+	}
+
+	// docLine ends the line if the enclosing group is broken. Otherwise, it is
+	// printed as a space, or as nothing if soft is true.
+	docLine struct {
+		soft bool
+	}
+
+	// docTrailing is printed as its contents, which aren't counted when
+	// measuring whether a group fits. Comments at the end of a line are
+	// trailing, as they can't be moved to another line.
+	docTrailing struct {
+		contents doc
+	}
+
+	// docSpace is a space, which is only printed if the line contains text.
+	docSpace struct{}
+
+	// docHardLine ends the line if it contains text, whether or not the
+	// enclosing group is broken.
+	docHardLine struct{}
+
+	// docNewLine always ends the line, so prints a blank line if the line is
+	// empty.
+	docNewLine struct{}
+
+	// docLiteralLine ends the line with "\n", exactly as it is in the source
+	// (e.g. within a multi-line string).
+	docLiteralLine struct{}
+
+	// docCursor marks the cursor as being at this offset within the next text
+	// printed.
+	docCursor int
+
+	// docOffset is called with the offset in the output at which the line after
+	// the last ended line starts.
+	docOffset func(offset int)
+)
+
+// docBuilder builds a document from a sequence of calls, mirroring the way a
+// document is printed.
+type docBuilder struct {
+	stack []docConcat // the documents being built, with the innermost last
+}
+
+func newDocBuilder() *docBuilder {
+	return &docBuilder{stack: []docConcat{nil}}
+}
+
+// root returns the document that was built.
+func (b *docBuilder) root() doc {
+	return b.stack[0]
+}
+
+func (b *docBuilder) add(d doc) {
+	b.stack[len(b.stack)-1] = append(b.stack[len(b.stack)-1], d)
+}
+
+// capture returns the document built by the calls made by fn, instead of
+// adding it to the current document.
+func (b *docBuilder) capture(fn func()) doc {
+	b.stack = append(b.stack, nil)
+	fn()
+	contents := b.stack[len(b.stack)-1]
+	b.stack = b.stack[:len(b.stack)-1]
+	return contents
+}
+
+// group adds a group containing the document built by fn.
+func (b *docBuilder) group(shouldBreak bool, fn func()) {
+	b.add(docGroup{contents: b.capture(fn), shouldBreak: shouldBreak})
+}
+
+// indentIfBreak adds the document built by fn, which is indented if the
+// enclosing group is broken.
+func (b *docBuilder) indentIfBreak(fn func()) {
+	contents := b.capture(fn)
+	b.add(docIfBreak{broken: docIndent{contents: contents}, flat: contents})
+}
+
+// trailing adds the document built by fn, which isn't counted when measuring
+// whether a group fits.
+func (b *docBuilder) trailing(fn func()) {
+	b.add(docTrailing{contents: b.capture(fn)})
+}
+
+// printString adds text. Line breaks within the text (i.e. in a multi-line
+// string) are printed exactly as they are in the source, without indentation.
+func (b *docBuilder) printString(strVal string) {
+	lines := strings.Split(strVal, "\n")
+	b.add(docText(lines[0]))
+	for _, line := range lines[1:] {
+		b.add(docLiteralLine{})
+		b.add(docRawText(line))
+	}
+}
+
+// appendToLine adds text that isn't indented, even if it starts the line.
+func (b *docBuilder) appendToLine(strVal string) {
+	b.add(docRawText(strVal))
+}
+
+// printSpace adds a space, which is only printed if the line isn't empty.
+func (b *docBuilder) printSpace() {
+	b.add(docSpace{})
+}
+
+// endLine ends the line, if it isn't empty.
+func (b *docBuilder) endLine() {
+	b.add(docHardLine{})
+}
+
+// printNewLine ends the line, even if it is empty.
+func (b *docBuilder) printNewLine() {
+	b.add(docNewLine{})
+}
+
+// line adds a line break, which is printed as a space if the enclosing group
+// isn't broken.
+func (b *docBuilder) line() {
+	b.add(docLine{})
+}
+
+// softLine adds a line break, which is printed as nothing if the enclosing
+// group isn't broken.
+func (b *docBuilder) softLine() {
+	b.add(docLine{soft: true})
+}
+
+// indent starts indenting the lines that follow by one more level, until the
+// matching call to unindent.
+func (b *docBuilder) indent() {
+	b.stack = append(b.stack, nil)
+}
+
+func (b *docBuilder) unindent() {
+	contents := b.stack[len(b.stack)-1]
+	b.stack = b.stack[:len(b.stack)-1]
+	b.add(docIndent{contents: contents})
+}
+
+// markCursor records that the cursor is at offset within the next text
+// printed.
+func (b *docBuilder) markCursor(offset int) {
+	b.add(docCursor(offset))
+}
+
+// markOffset adds a call to fn with the offset in the output at which the
+// line after the last ended line starts.
+func (b *docBuilder) markOffset(fn func(offset int)) {
+	b.add(docOffset(fn))
+}
@@ -19,20 +19,50 @@
 package formatter
 
 import (
-	"errors"
 	"fmt"
 
 	"github.com/antlr4-go/antlr/v4"
 	"go.uber.org/zap"
 )
 
+// SyntaxError is returned when the source code cannot be parsed.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error on line %d:%d - %s", e.Line, e.Column, e.Msg)
+}
+
 type ErrorListener struct {
 	antlr.DefaultErrorListener
 	lastErr error
+	errs    []*SyntaxError // all of the errors, in the order they were reported
+	logger  *zap.Logger
 }
 
 func (e *ErrorListener) SyntaxError(_ antlr.Recognizer, _ interface{}, line int, column int, msg string, _ antlr.RecognitionException) {
-	errMsg := fmt.Sprintf("syntax error on line %d:%d - %s", line, column, msg)
-	zap.L().Error(errMsg)
-	e.lastErr = errors.New(errMsg)
+	syntaxErr := &SyntaxError{Line: line, Column: column, Msg: msg}
+	// the error is logged with the result for the file, so that messages for
+	// files formatted in parallel are not interleaved
+	e.logger.Debug(syntaxErr.Error())
+	e.lastErr = syntaxErr
+	e.errs = append(e.errs, syntaxErr)
+}
+
+// SyntaxErrors is the error returned by FormatSource when the source code
+// cannot be parsed. It holds all of the errors reported by the parser, and
+// unwraps to the last one.
+type SyntaxErrors struct {
+	Errors []*SyntaxError // in the order they were reported
+}
+
+func (e *SyntaxErrors) Error() string {
+	return e.Unwrap().Error()
+}
+
+func (e *SyntaxErrors) Unwrap() error {
+	return e.Errors[len(e.Errors)-1]
 }
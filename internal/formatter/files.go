@@ -0,0 +1,226 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hugheaves/scadformat/internal/ignore"
+	"go.uber.org/zap"
+)
+
+const (
+	sourceFileExt = ".scad"
+	backupFileExt = ".scadbak"
+	stdinFileName = "<stdin>"
+
+	ignoreFileName    = ".scadformatignore"
+	gitIgnoreFileName = ".gitignore"
+)
+
+// sourceFile is a file found by findSourceFiles. If the file could not be
+// accessed, err is set and the file should be reported as failed. Files that
+// match the ignore rules are returned with ignored set, so that they can be
+// reported, and should not be formatted.
+type sourceFile struct {
+	path    string
+	err     error
+	ignored bool
+}
+
+// findSourceFiles expands the target paths into a list of OpenSCAD source files.
+// Files are returned in the order the targets were specified, with the contents of
+// each directory in lexical order. Directories are only searched below their top
+// level when recurse is true.
+//
+// Files and directories that match the ignore rules are skipped, although
+// ignored files are still returned, marked as ignored. Ignored target paths are
+// reported with a warning, and ignored paths found in directories are only
+// logged at debug level.
+func findSourceFiles(targetPaths []string, recurse bool, ignoreMatcher *ignore.Matcher) []sourceFile {
+	var files []sourceFile
+	seen := make(map[string]bool)
+	add := func(file sourceFile) {
+		key := filepath.Clean(file.path)
+		if !seen[key] {
+			seen[key] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, targetPath := range targetPaths {
+		info, err := os.Stat(targetPath)
+		if err != nil {
+			add(sourceFile{path: targetPath, err: err})
+			continue
+		}
+		rule, err := ignoreMatcher.Match(targetPath, info.IsDir())
+		if err != nil {
+			add(sourceFile{path: targetPath, err: err})
+			continue
+		}
+		if rule != nil {
+			zap.S().Warnf("skipping %s: ignored by %s", targetPath, rule)
+			if !info.IsDir() {
+				add(sourceFile{path: targetPath, ignored: true})
+			}
+			continue
+		}
+		if !info.IsDir() {
+			if isSourceFile(targetPath) {
+				add(sourceFile{path: targetPath})
+			} else {
+				zap.S().Warnf("skipping %s: not a %s file", targetPath, sourceFileExt)
+			}
+			continue
+		}
+		err = filepath.WalkDir(targetPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				add(sourceFile{path: path, err: err})
+				return nil
+			}
+			if path == targetPath {
+				return nil
+			}
+			if d.IsDir() && !recurse {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && !isSourceFile(path) {
+				zap.S().Debugf("skipping %s: not a %s file", path, sourceFileExt)
+				return nil
+			}
+			rule, err := ignoreMatcher.Match(path, d.IsDir())
+			if err != nil {
+				add(sourceFile{path: path, err: err})
+				return nil
+			}
+			if rule != nil {
+				zap.S().Debugf("skipping %s: ignored by %s", path, rule)
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				add(sourceFile{path: path, ignored: true})
+				return nil
+			}
+			if !d.IsDir() {
+				add(sourceFile{path: path})
+			}
+			return nil
+		})
+		if err != nil {
+			add(sourceFile{path: targetPath, err: err})
+		}
+	}
+	return files
+}
+
+// isSourceFile returns true if the file name has the OpenSCAD source file extension.
+// Backup files (.scadbak) are never considered source files.
+func isSourceFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), sourceFileExt)
+}
+
+// writeFile replaces the contents of an existing file. The data is written to a
+// temporary file in the same directory, which is then renamed over the original,
+// so that the original is left intact if the write fails part way through. The
+// file mode and owner of the original are preserved, as is its modification time
+// if preserveModTime is true. If the owner can't be copied to the temporary file,
+// the original is overwritten in place instead. If fileName is a symbolic link,
+// the file that it refers to is replaced.
+func writeFile(fileName string, data []byte, preserveModTime bool) (err error) {
+	target, err := filepath.EvalSymlinks(fileName)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+		}
+	}()
+
+	_, err = tempFile.Write(data)
+	if err != nil {
+		return err
+	}
+	err = tempFile.Sync()
+	if err != nil {
+		return err
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		err = tempFile.Chown(uid, gid)
+		if err != nil {
+			zap.S().Debugf("unable to copy the owner of %s, overwriting it in place: %v", target, err)
+			tempFile.Close()
+			os.Remove(tempFile.Name())
+			return overwriteFile(target, data, info, preserveModTime)
+		}
+	}
+	// chown clears the setuid and setgid bits, so the mode is set afterwards
+	err = tempFile.Chmod(info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky))
+	if err != nil {
+		return err
+	}
+	err = tempFile.Close()
+	if err != nil {
+		return err
+	}
+	if preserveModTime {
+		err = os.Chtimes(tempFile.Name(), time.Time{}, info.ModTime())
+		if err != nil {
+			return err
+		}
+	}
+	return os.Rename(tempFile.Name(), target)
+}
+
+// overwriteFile replaces the contents of an existing file by truncating and
+// rewriting it, which keeps its owner and mode.
+func overwriteFile(target string, data []byte, info os.FileInfo, preserveModTime bool) error {
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	if preserveModTime {
+		return os.Chtimes(target, time.Time{}, info.ModTime())
+	}
+	return nil
+}
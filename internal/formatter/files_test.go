@@ -0,0 +1,379 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hugheaves/scadformat/internal/config"
+	"github.com/hugheaves/scadformat/internal/ignore"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(path), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// sourceFilePaths returns the paths of the files that are not ignored.
+func sourceFilePaths(t *testing.T, files []sourceFile) []string {
+	var paths []string
+	for _, file := range files {
+		if file.err != nil {
+			t.Fatal(file.err)
+		}
+		if !file.ignored {
+			paths = append(paths, file.path)
+		}
+	}
+	return paths
+}
+
+func TestFindSourceFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"b.scad":               "",
+		"a.scad":               "",
+		"a_2025-01-01.scadbak": "",
+		"notes.txt":            "",
+		"sub/c.scad":           "",
+	})
+
+	files := sourceFilePaths(t, findSourceFiles([]string{root}, false, ignore.NewMatcher()))
+	expected := []string{filepath.Join(root, "a.scad"), filepath.Join(root, "b.scad")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected %v, got %v", expected, files)
+	}
+
+	files = sourceFilePaths(t, findSourceFiles([]string{root, filepath.Join(root, "a.scad")}, true, ignore.NewMatcher()))
+	expected = append(expected, filepath.Join(root, "sub", "c.scad"))
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected %v, got %v", expected, files)
+	}
+}
+
+func TestFindSourceFilesIgnored(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".git/HEAD":          "",
+		".scadformatignore":  "lib/BOSL2/\n*.gen.scad\n",
+		".gitignore":         "build/\n",
+		"main.scad":          "",
+		"part.gen.scad":      "",
+		"lib/BOSL2/std.scad": "",
+		"lib/local.scad":     "",
+		"build/out.scad":     "",
+	})
+
+	found := findSourceFiles([]string{root, filepath.Join(root, "part.gen.scad")}, true, ignore.NewMatcher(ignoreFileName))
+	var ignored []string
+	for _, file := range found {
+		if file.ignored {
+			ignored = append(ignored, file.path)
+		}
+	}
+	if expected := []string{filepath.Join(root, "part.gen.scad")}; !reflect.DeepEqual(ignored, expected) {
+		t.Fatalf("expected ignored files %v, got %v", expected, ignored)
+	}
+
+	files := sourceFilePaths(t, found)
+	expected := []string{
+		filepath.Join(root, "build", "out.scad"),
+		filepath.Join(root, "lib", "local.scad"),
+		filepath.Join(root, "main.scad"),
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected %v, got %v", expected, files)
+	}
+
+	files = sourceFilePaths(t, findSourceFiles([]string{root}, true, ignore.NewMatcher(ignoreFileName, gitIgnoreFileName)))
+	if !reflect.DeepEqual(files, expected[1:]) {
+		t.Fatalf("expected %v, got %v", expected[1:], files)
+	}
+}
+
+func TestFindSourceFilesMissing(t *testing.T) {
+	files := findSourceFiles([]string{filepath.Join(t.TempDir(), "missing.scad")}, false, ignore.NewMatcher())
+	if len(files) != 1 || files[0].err == nil {
+		t.Fatalf("expected a single error result, got %v", files)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	root := t.TempDir()
+	unformatted := "x=1;\n"
+	writeTree(t, root, map[string]string{
+		"formatted.scad":   "x = 1;\n",
+		"unformatted.scad": unformatted,
+		"invalid.scad":     "x = ;\n",
+	})
+
+	summary, err := NewFormatter(&config.MainConfig{Check: true, TargetPaths: []string{root}}).Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for status, expected := range map[FileStatus]int{StatusUnchanged: 1, StatusWouldReformat: 1, StatusSyntaxError: 1} {
+		if summary.Count(status) != expected {
+			t.Errorf("expected %d %s files, got %d", expected, status, summary.Count(status))
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(root, "unformatted.scad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != unformatted {
+		t.Fatal("check mode modified file")
+	}
+}
+
+func TestCheckParallel(t *testing.T) {
+	root := t.TempDir()
+	files := make(map[string]string)
+	var expected strings.Builder
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("part%02d.scad", i)
+		if i%3 == 0 {
+			files[name] = "x = 1;\n"
+		} else {
+			files[name] = "x=1;\n"
+			expected.WriteString(filepath.Join(root, name) + "\n")
+		}
+	}
+	writeTree(t, root, files)
+
+	var stdout strings.Builder
+	f := NewFormatter(&config.MainConfig{Check: true, Jobs: 8, TargetPaths: []string{root}})
+	f.stdout = &stdout
+	summary, err := f.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Total() != 50 || summary.Count(StatusUnchanged) != 17 {
+		t.Errorf("unexpected summary: %s", summary)
+	}
+	if stdout.String() != expected.String() {
+		t.Errorf("expected files in sorted order:\n%s\ngot:\n%s", expected.String(), stdout.String())
+	}
+}
+
+func TestStdinFilePath(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".git/HEAD":                  "",
+		config.ProjectConfigFileName: "indent_size = 4\n",
+		".scadformatignore":          "vendor/\n",
+	})
+
+	for _, test := range []struct {
+		path     string
+		input    string
+		expected string
+	}{
+		{"", "module a(){\ncube(1);\n}\n", "module a() {\n  cube(1);\n}\n"},
+		{filepath.Join(root, "part.scad"), "module a(){\ncube(1);\n}\n", "module a() {\n    cube(1);\n}\n"},
+		{filepath.Join(root, "vendor", "lib.scad"), "module a(){\ncube(1);\n}\n", "module a(){\ncube(1);\n}\n"},
+	} {
+		var stdout strings.Builder
+		f := NewFormatter(&config.MainConfig{StdinFilePath: test.path})
+		f.stdin = strings.NewReader(test.input)
+		f.stdout = &stdout
+		summary, err := f.Format()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Total() != 1 || stdout.String() != test.expected {
+			t.Errorf("%q: expected %q, got %q (%s)", test.path, test.expected, stdout.String(), summary)
+		}
+	}
+
+	f := NewFormatter(&config.MainConfig{StdinFilePath: filepath.Join(root, "part.scad")})
+	f.stdin = strings.NewReader("x = ;\n")
+	f.stdout = &strings.Builder{}
+	result := f.formatStdio()
+	if result.Status != StatusSyntaxError || result.Path != filepath.Join(root, "part.scad") {
+		t.Errorf("expected a syntax error in part.scad, got %s: %s", result.Path, result.Status)
+	}
+}
+
+func TestWriteFilePreservesMetadata(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "part.scad")
+	err := os.WriteFile(fileName, []byte("x=1;\n"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	err = os.Chtimes(fileName, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeFile(fileName, []byte("x = 1;\n"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0640 {
+		t.Errorf("expected mode 0640, got %o", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("expected modification time %s, got %s", modTime, info.ModTime())
+	}
+	entries, err := os.ReadDir(filepath.Dir(fileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected temporary file to be removed, found %d files", len(entries))
+	}
+}
+
+func TestWriteFilePreservesSetuid(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("setuid isn't supported on windows")
+	}
+	fileName := filepath.Join(t.TempDir(), "part.scad")
+	err := os.WriteFile(fileName, []byte("x=1;\n"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chmod(fileName, 0750|os.ModeSetuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeFile(fileName, []byte("x = 1;\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0750|os.ModeSetuid {
+		t.Errorf("expected mode %s, got %s", 0750|os.ModeSetuid, info.Mode())
+	}
+}
+
+func TestOverwriteFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "part.scad")
+	err := os.WriteFile(fileName, []byte("x=1;\ny=2;\n"), 0640)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = overwriteFile(fileName, []byte("x = 1;\n"), info, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "x = 1;\n" {
+		t.Errorf("unexpected file contents %q", data)
+	}
+	newInfo, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(info, newInfo) || !newInfo.ModTime().Equal(info.ModTime()) {
+		t.Error("expected the file to be overwritten in place with its modification time kept")
+	}
+}
+
+func TestFormattedFileNotTouched(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"part.scad": "x = 1;\n"})
+	fileName := filepath.Join(root, "part.scad")
+	modTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := os.Chtimes(fileName, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := NewFormatter(&config.MainConfig{TargetPaths: []string{root}}).Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Count(StatusUnchanged) != 1 {
+		t.Fatalf("expected file to be unchanged: %s", summary)
+	}
+
+	info, err := os.Stat(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("formatted file was rewritten")
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no backup of formatted file, found %d files", len(entries))
+	}
+}
+
+func TestDumpConfig(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".scadformat.toml": "indent_size = 4\n",
+		"a.scad":           "x=1;\n",
+		"b.scad":           "x=1;\n",
+	})
+
+	var stdout strings.Builder
+	f := NewFormatter(&config.MainConfig{TargetPaths: []string{filepath.Join(root, "a.scad"), filepath.Join(root, "b.scad")}})
+	f.stdout = &stdout
+	err := f.DumpConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := stdout.String()
+	if strings.Count(output, "indent_size = 4") != 2 || !strings.Contains(output, "# "+filepath.Join(root, "b.scad")+"\n") {
+		t.Errorf("unexpected config dump:\n%s", output)
+	}
+}
@@ -18,18 +18,141 @@
 
 package formatter
 
-import "math"
+import (
+	"fmt"
+	"math"
+
+	"github.com/hugheaves/scadformat/internal/config"
+)
 
 type FormatSettings struct {
-	fileName   string
-	maxLineLen int
-	indentSize int
+	maxLineLen             int
+	indentSize             int
+	useTabs                bool               // indent with tabs instead of spaces
+	tabWidth               int                // number of columns a tab is displayed as (0 for the indent size)
+	endOfLine              string             // line ending written at the end of each line
+	insertFinalNewline     bool               // end the output with a line ending
+	trimTrailingWhitespace bool               // remove whitespace at the end of lines
+	lines                  []config.LineRange // only reformat statements that overlap these lines (nil for all)
+	moduleBraceStyle       string             // brace placement for module definitions
+	controlBraceStyle      string             // brace placement for if, else and for
+	childrenBraceStyle     string             // brace placement for the children of other modules
+	functionBodyStyle      string             // placement of function bodies
 }
 
-func DefaultFormatSettings(fileName string) *FormatSettings {
+func DefaultFormatSettings() *FormatSettings {
 	return &FormatSettings{
-		fileName:   fileName,
-		maxLineLen: math.MaxInt,
-		indentSize: 2,
+		maxLineLen:             math.MaxInt,
+		indentSize:             2,
+		useTabs:                false,
+		endOfLine:              "\n",
+		insertFinalNewline:     true,
+		trimTrailingWhitespace: false,
+		moduleBraceStyle:       config.BraceStyleKR,
+		controlBraceStyle:      config.BraceStyleKR,
+		childrenBraceStyle:     config.BraceStyleKR,
+		functionBodyStyle:      config.FunctionBodyBreak,
+	}
+}
+
+// fingerprint returns a string that identifies the settings, for use in cache
+// keys. Settings that format code differently have different fingerprints.
+func (s *FormatSettings) fingerprint() string {
+	return fmt.Sprintf("%#v", *s)
+}
+
+var endOfLineOptions = map[string]string{
+	config.EndOfLineLF:   "\n",
+	config.EndOfLineCRLF: "\r\n",
+	config.EndOfLineCR:   "\r",
+}
+
+// options returns the settings as project configuration options.
+func (s *FormatSettings) options() config.FormatOptions {
+	maxLineLen := s.maxLineLen
+	if maxLineLen == math.MaxInt {
+		maxLineLen = 0
+	}
+	indentSize := s.indentSize
+	var tabWidth *int
+	if s.tabWidth > 0 {
+		width := s.tabWidth
+		tabWidth = &width
+	}
+	indentStyle := config.IndentStyleSpace
+	if s.useTabs {
+		indentStyle = config.IndentStyleTab
+	}
+	var endOfLine string
+	for option, value := range endOfLineOptions {
+		if value == s.endOfLine {
+			endOfLine = option
+		}
+	}
+	insertFinalNewline := s.insertFinalNewline
+	trimTrailingWhitespace := s.trimTrailingWhitespace
+	moduleBraceStyle, controlBraceStyle, childrenBraceStyle := s.moduleBraceStyle, s.controlBraceStyle, s.childrenBraceStyle
+	functionBodyStyle := s.functionBodyStyle
+	return config.FormatOptions{
+		IndentStyle:            &indentStyle,
+		IndentSize:             &indentSize,
+		TabWidth:               tabWidth,
+		MaxLineLength:          &maxLineLen,
+		EndOfLine:              &endOfLine,
+		InsertFinalNewline:     &insertFinalNewline,
+		TrimTrailingWhitespace: &trimTrailingWhitespace,
+		ModuleBraceStyle:       &moduleBraceStyle,
+		ControlBraceStyle:      &controlBraceStyle,
+		ChildrenBraceStyle:     &childrenBraceStyle,
+		FunctionBodyStyle:      &functionBodyStyle,
+	}
+}
+
+// apply updates the settings from the project configuration options that are set.
+func (s *FormatSettings) apply(options *config.FormatOptions) {
+	if options.IndentStyle != nil {
+		s.useTabs = *options.IndentStyle == config.IndentStyleTab
+	}
+	if options.IndentSize != nil {
+		s.indentSize = *options.IndentSize
+	}
+	if options.TabWidth != nil {
+		s.tabWidth = *options.TabWidth
+	}
+	if options.MaxLineLength != nil {
+		s.maxLineLen = *options.MaxLineLength
+		if s.maxLineLen == 0 {
+			s.maxLineLen = math.MaxInt
+		}
+	}
+	if options.EndOfLine != nil {
+		s.endOfLine = endOfLineOptions[*options.EndOfLine]
+	}
+	if options.InsertFinalNewline != nil {
+		s.insertFinalNewline = *options.InsertFinalNewline
+	}
+	if options.TrimTrailingWhitespace != nil {
+		s.trimTrailingWhitespace = *options.TrimTrailingWhitespace
+	}
+	if options.ModuleBraceStyle != nil {
+		s.moduleBraceStyle = *options.ModuleBraceStyle
+	}
+	if options.ControlBraceStyle != nil {
+		s.controlBraceStyle = *options.ControlBraceStyle
+	}
+	if options.ChildrenBraceStyle != nil {
+		s.childrenBraceStyle = *options.ChildrenBraceStyle
+	}
+	if options.FunctionBodyStyle != nil {
+		s.functionBodyStyle = *options.FunctionBodyStyle
+	}
+}
+
+// tabColumns returns the number of columns that a tab is displayed as, which
+// is used to measure the length of lines.
+func (s *FormatSettings) tabColumns() int {
+	if s.tabWidth > 0 {
+		return s.tabWidth
 	}
+	return max(s.indentSize, 1)
 }
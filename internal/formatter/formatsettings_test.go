@@ -0,0 +1,181 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"testing"
+
+	"github.com/hugheaves/scadformat/internal/config"
+	"go.uber.org/zap"
+)
+
+func TestFormatSettings(t *testing.T) {
+	input := "module a() {\r\ncube(1); /* note   \r\n   end */\r\necho(\"x\r\ny\");\r\n}\r\n"
+
+	tab := config.IndentStyleTab
+	crlf := config.EndOfLineCRLF
+	indentSize, noIndent := 4, 0
+	yes, no := true, false
+
+	tests := []struct {
+		name     string
+		options  config.FormatOptions
+		expected string
+	}{
+		{"defaults", config.FormatOptions{},
+			"module a() {\n  cube(1); /* note   \n   end */\n  echo(\"x\r\ny\");\n}\n"},
+		{"tabs", config.FormatOptions{IndentStyle: &tab, IndentSize: &indentSize},
+			"module a() {\n\tcube(1); /* note   \n   end */\n\techo(\"x\r\ny\");\n}\n"},
+		// tabs are written for each level, whatever the indent size
+		{"tabs without indent size", config.FormatOptions{IndentStyle: &tab, IndentSize: &noIndent},
+			"module a() {\n\tcube(1); /* note   \n   end */\n\techo(\"x\r\ny\");\n}\n"},
+		{"crlf", config.FormatOptions{EndOfLine: &crlf, TrimTrailingWhitespace: &yes},
+			"module a() {\r\n  cube(1); /* note\r\n   end */\r\n  echo(\"x\r\ny\");\r\n}\r\n"},
+		{"no final newline", config.FormatOptions{InsertFinalNewline: &no},
+			"module a() {\n  cube(1); /* note   \n   end */\n  echo(\"x\r\ny\");\n}"},
+	}
+	for _, test := range tests {
+		settings := DefaultFormatSettings()
+		settings.apply(&test.options)
+		output, err := formatSource([]byte(input), settings, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, string(output))
+		}
+	}
+}
+
+// Test that wrapped lines are indented with tabs, and that tabs are measured
+// with the tab width
+func TestTabWrapping(t *testing.T) {
+	input := "module a() {\ncube([width, height, depth]);\n}\n"
+
+	tab := config.IndentStyleTab
+	maxLineLength := 32
+	tests := []struct {
+		tabWidth int
+		expected string
+	}{
+		{2, "module a() {\n\tcube([width, height, depth]);\n}\n"},
+		{4, "module a() {\n\tcube(\n\t\t[width, height, depth]\n\t);\n}\n"},
+	}
+	for _, test := range tests {
+		settings := DefaultFormatSettings()
+		settings.apply(&config.FormatOptions{IndentStyle: &tab, TabWidth: &test.tabWidth, MaxLineLength: &maxLineLength})
+		output, err := formatSource([]byte(input), settings, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != test.expected {
+			t.Errorf("tab width %d: expected %q, got %q", test.tabWidth, test.expected, string(output))
+		}
+	}
+}
+
+func TestBraceStyle(t *testing.T) {
+	input := "module a() { if (x) { cube(1); } else { for (i = [0:1]) { sphere(i); } } translate([1, 0, 0]) { cube(2); } }\n"
+
+	kr, allman := config.BraceStyleKR, config.BraceStyleAllman
+	tests := []struct {
+		name     string
+		options  config.FormatOptions
+		expected string
+	}{
+		{"k&r", config.FormatOptions{},
+			"module a() {\n  if (x) {\n    cube(1);\n  } else {\n    for(i = [0:1]) {\n      sphere(i);\n    }\n  }\n  translate([1, 0, 0]) {\n    cube(2);\n  }\n}\n"},
+		{"allman modules", config.FormatOptions{ModuleBraceStyle: &allman},
+			"module a()\n{\n  if (x) {\n    cube(1);\n  } else {\n    for(i = [0:1]) {\n      sphere(i);\n    }\n  }\n  translate([1, 0, 0]) {\n    cube(2);\n  }\n}\n"},
+		{"allman control flow", config.FormatOptions{ControlBraceStyle: &allman},
+			"module a() {\n  if (x)\n  {\n    cube(1);\n  }\n  else\n  {\n    for(i = [0:1])\n    {\n      sphere(i);\n    }\n  }\n  translate([1, 0, 0]) {\n    cube(2);\n  }\n}\n"},
+		{"allman children", config.FormatOptions{ControlBraceStyle: &kr, ChildrenBraceStyle: &allman},
+			"module a() {\n  if (x) {\n    cube(1);\n  } else {\n    for(i = [0:1]) {\n      sphere(i);\n    }\n  }\n  translate([1, 0, 0])\n  {\n    cube(2);\n  }\n}\n"},
+	}
+	for _, test := range tests {
+		settings := DefaultFormatSettings()
+		settings.apply(&test.options)
+		output, err := formatSource([]byte(input), settings, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, string(output))
+		}
+	}
+}
+
+func TestFunctionBodyStyle(t *testing.T) {
+	input := "function sq(x) = x * x;\nfunction ab(x) = x > 0 ? x : -x;\nf = function(x) x + 1;\nfunction c(x) = // note\nx;\n"
+
+	tests := []struct {
+		style    string
+		expected string
+	}{
+		{config.FunctionBodyBreak,
+			"function sq(x) =\n  x * x;\nfunction ab(x) =\n  x > 0 ? x : -x;\nf = function(x) x + 1;\nfunction c(x) = // note\n  x;\n"},
+		{config.FunctionBodyFit,
+			"function sq(x) = x * x;\nfunction ab(x) = x > 0 ? x : -x;\nf = function(x) x + 1;\nfunction c(x) = // note\n  x;\n"},
+		{config.FunctionBodyBreakComplex,
+			"function sq(x) = x * x;\nfunction ab(x) =\n  x > 0 ? x : -x;\nf = function(x) x + 1;\nfunction c(x) = // note\n  x;\n"},
+	}
+	for _, test := range tests {
+		settings := DefaultFormatSettings()
+		settings.apply(&config.FormatOptions{FunctionBodyStyle: &test.style})
+		output, err := formatSource([]byte(input), settings, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.style, test.expected, string(output))
+		}
+	}
+}
+
+func TestFunctionLiteralBodyStyle(t *testing.T) {
+	input := "echo(map(function(v)v * 2, list));\nx = [function(a) a, function(b) b];\n"
+	flat := "echo(map(function(v) v * 2, list));\nx = [function(a) a, function(b) b];\n"
+	fit := "echo(\n  map(function(v) v * 2, list)\n);\nx = [\n  function(a) a,\n  function(b) b\n];\n"
+
+	tests := []struct {
+		style         string
+		maxLineLength int
+		expected      string
+	}{
+		// without a maximum line length, the "break" style leaves literals on one line
+		{config.FunctionBodyBreak, 0, flat},
+		{config.FunctionBodyBreak, 30,
+			"echo(\n  map(\n    function(v)\n      v * 2,\n    list\n  )\n);\nx = [\n  function(a)\n    a,\n  function(b)\n    b\n];\n"},
+		{config.FunctionBodyFit, 0, flat},
+		{config.FunctionBodyFit, 30, fit},
+		{config.FunctionBodyBreakComplex, 0, flat},
+		{config.FunctionBodyBreakComplex, 30, fit},
+	}
+	for _, test := range tests {
+		settings := DefaultFormatSettings()
+		settings.apply(&config.FormatOptions{FunctionBodyStyle: &test.style, MaxLineLength: &test.maxLineLength})
+		output, err := formatSource([]byte(input), settings, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != test.expected {
+			t.Errorf("%s, max line length %d: expected %q, got %q", test.style, test.maxLineLength, test.expected, string(output))
+		}
+	}
+}
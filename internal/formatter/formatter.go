@@ -20,109 +20,549 @@ package formatter
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
-	"strings"
+	"sort"
 	"time"
 
 	"github.com/antlr4-go/antlr/v4"
+	"github.com/hugheaves/scadformat/internal/cache"
+	"github.com/hugheaves/scadformat/internal/config"
+	"github.com/hugheaves/scadformat/internal/ignore"
 	"github.com/hugheaves/scadformat/internal/parser"
 	"go.uber.org/zap"
 )
 
 type Formatter struct {
-	settings *FormatSettings
+	config        *config.MainConfig
+	settings      *FormatSettings // settings used when formatting stdin
+	projectConfig *config.ProjectConfigLoader
+	ignore        *ignore.Matcher
+	stdin         io.Reader // source code to format when there are no target paths
+	stdout        io.Writer // where formatted stdin, file names (check mode) and diffs (diff mode) are written
+	colorDiffs    bool
+	changedLines  map[string][]config.LineRange // lines changed according to git, by canonical path
+	repoRoots     map[string]string             // repository root of each changed or staged file, by canonical path
+	cache         *cache.Cache                  // records files that are already formatted (nil if disabled)
+	buildHash     string                        // identifies the build of the formatter in cache keys
 }
 
-func NewFormatter(fileName string) *Formatter {
-	return &Formatter{
-		settings: DefaultFormatSettings(fileName),
+func NewFormatter(mainConfig *config.MainConfig) *Formatter {
+	ignoreFileNames := []string{ignoreFileName}
+	if mainConfig.RespectGitignore {
+		ignoreFileNames = append(ignoreFileNames, gitIgnoreFileName)
 	}
+	settings := DefaultFormatSettings()
+	settings.lines = mainConfig.Lines
+	f := &Formatter{
+		config:        mainConfig,
+		settings:      settings,
+		projectConfig: config.NewProjectConfigLoader(),
+		ignore:        ignore.NewMatcher(ignoreFileNames...),
+		stdin:         os.Stdin,
+		stdout:        os.Stdout,
+		colorDiffs:    useColor(os.Stdout),
+	}
+	if mainConfig.CacheDir != "" {
+		executableHash, err := cache.ExecutableHash()
+		if err != nil {
+			zap.S().Warnf("cache disabled: %s", err)
+		} else {
+			f.cache = cache.New(mainConfig.CacheDir)
+			f.buildHash = executableHash
+		}
+	}
+	return f
 }
 
-func (f *Formatter) Format() error {
-	if f.settings.fileName != "" {
-		return f.formatFile()
+// Format formats the files and directories in the configured target paths. If
+// no target paths are configured, source code is read from stdin and the
+// formatted code is written to stdout. When only formatting the lines changed
+// according to git, the changed files are formatted instead of stdin.
+//
+// In check mode, files are not modified. Instead, the name of each file that is
+// not correctly formatted is printed to stdout. In diff mode, files are also not
+// modified, and a unified diff of the changes is printed to stdout instead. In
+// JSON mode, a JSON report of the results is printed instead of file names or
+// diffs.
+func (f *Formatter) Format() (*Summary, error) {
+	summary := NewSummary()
+	var results []*FileResult
+	report := func(result *FileResult) {
+		if f.config.JSON {
+			results = append(results, result)
+		} else {
+			f.writeReport(result)
+		}
+		summary.Add(result)
+	}
+
+	if len(f.config.TargetPaths) == 0 && !f.formatChangedOnly() {
+		start := time.Now()
+		result := f.formatStdio()
+		result.Elapsed = time.Since(start)
+		report(result)
+		if f.config.Check || result.Status.IsError() {
+			result.log()
+		}
+		return summary, f.writeJSONReport(results, summary)
+	}
+
+	var files []sourceFile
+	if f.formatChangedOnly() {
+		var err error
+		files, err = f.findChangedFiles()
+		if err != nil {
+			return nil, err
+		}
 	} else {
-		return f.formatStdio()
+		files = findSourceFiles(f.config.TargetPaths, f.config.Recurse, f.ignore)
 	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].path < files[j].path
+	})
+	f.formatFiles(files, func(result *FileResult) {
+		report(result)
+		result.log()
+	})
+	f.pruneCache()
+
+	zap.S().Info(summary)
+	return summary, f.writeJSONReport(results, summary)
 }
 
-func (f *Formatter) formatFile() error {
-	zap.S().Infof("formatting file %s", f.settings.fileName)
-	err := checkFile(f.settings.fileName)
+// formatFiles formats files in parallel, using the configured number of
+// workers. The results are passed to report in the same order as the files,
+// regardless of the order in which formatting finishes.
+func (f *Formatter) formatFiles(files []sourceFile, report func(result *FileResult)) {
+	results := make([]chan *FileResult, len(files))
+	for i := range results {
+		results[i] = make(chan *FileResult, 1)
+	}
+
+	indexes := make(chan int)
+	go func() {
+		for i := range files {
+			indexes <- i
+		}
+		close(indexes)
+	}()
+
+	workers := min(max(f.config.Jobs, 1), len(files))
+	for range workers {
+		go func() {
+			for i := range indexes {
+				start := time.Now()
+				result := f.formatSourceFile(files[i])
+				result.Elapsed = time.Since(start)
+				results[i] <- result
+			}
+		}()
+	}
+
+	for _, result := range results {
+		report(<-result)
+	}
+}
+
+// formatSourceFile formats a file found by findSourceFiles.
+func (f *Formatter) formatSourceFile(file sourceFile) *FileResult {
+	switch {
+	case file.err != nil:
+		result := &FileResult{Path: file.path}
+		result.setError(file.err)
+		return result
+	case file.ignored:
+		return &FileResult{Path: file.path, Status: StatusIgnored}
+	default:
+		return f.formatFile(file.path)
+	}
+}
+
+func (f *Formatter) formatFile(fileName string) *FileResult {
+	zap.S().Debugf("formatting file %s", fileName)
+	result := &FileResult{Path: fileName}
+
+	err := checkFile(fileName)
 	if err != nil {
-		return err
+		result.setError(err)
+		return result
 	}
 
-	input, err := os.ReadFile(f.settings.fileName)
+	input, err := os.ReadFile(fileName)
 	if err != nil {
-		zap.S().Errorf("failed to read file %s: %s", f.settings.fileName, err)
-		return err
+		result.setError(fmt.Errorf("failed to read file: %w", err))
+		return result
 	}
 
-	output, err := f.formatBytes(input)
+	settings, err := f.settingsFor(fileName)
 	if err != nil {
-		zap.S().Errorf("failed to format file %s: %s", f.settings.fileName, err)
-		return err
+		result.setError(err)
+		return result
+	}
+
+	key := f.cacheKey(input, settings)
+	if f.isFormatted(key) {
+		zap.S().Debugf("skipping %s: already formatted (cached)", fileName)
+		result.output = input
+		result.Status = StatusUnchanged
+		return result
 	}
 
-	timeStamp := time.Now().Format("2006-01-02_15-04-05")
-	backupFileName := strings.TrimSuffix(f.settings.fileName, filepath.Ext(f.settings.fileName)) + "_" + timeStamp + ".scadbak"
-	err = os.WriteFile(backupFileName, input, 0666)
+	output, err := formatSource(input, settings, zap.L())
 	if err != nil {
-		zap.S().Errorf("failed to write file %s: %s", backupFileName, err)
-		return err
+		result.setError(err)
+		return result
+	}
+
+	result.output = output
+	result.setChanges(f.changeStatus(input, output), input, output)
+	if result.Status == StatusUnchanged {
+		f.addFormatted(key)
+	}
+	if f.dryRun() {
+		f.reportChanges(result, input, output)
+		return result
+	}
+	if result.Status == StatusUnchanged {
+		// don't touch files that are already formatted
+		return result
 	}
 
-	err = os.WriteFile(f.settings.fileName, output, 0666)
+	err = f.backupFile(fileName, input)
 	if err != nil {
-		zap.S().Errorf("failed to write file %s: %s", f.settings.fileName, err)
-		return err
+		result.setError(err)
+		return result
 	}
-	return nil
+
+	err = writeFile(fileName, output, f.config.PreserveTimestamp)
+	if err != nil {
+		result.setError(fmt.Errorf("failed to write file: %w", err))
+		return result
+	}
+	return result
 }
 
-func (f *Formatter) formatStdio() error {
-	input, err := io.ReadAll(os.Stdin)
+// formatStdio formats source code read from stdin. If a stdin file path is
+// configured, it is used to find the project configuration and ignore rules,
+// and to name the code in messages and diffs. Ignored code is written to stdout
+// unchanged.
+func (f *Formatter) formatStdio() *FileResult {
+	result := &FileResult{Path: stdinFileName}
+	if f.config.StdinFilePath != "" {
+		result.Path = f.config.StdinFilePath
+	}
+
+	input, err := io.ReadAll(f.stdin)
 	if err != nil {
-		zap.S().Errorf("failed to read data: %s", err)
-		return err
+		result.setError(fmt.Errorf("failed to read data: %w", err))
+		return result
 	}
 
-	output, err := f.formatBytes(input)
+	output := input
+	cursor := -1
+	if f.config.CursorOffset != nil {
+		cursor = min(*f.config.CursorOffset, len(input))
+	}
+	ignored := false
+	if f.config.StdinFilePath != "" {
+		ignored, err = f.isIgnored(f.config.StdinFilePath)
+		if err != nil {
+			result.setError(err)
+			return result
+		}
+	}
+	if ignored {
+		result.Status = StatusIgnored
+	} else {
+		output, cursor, err = f.formatStdin(input)
+		if err != nil {
+			result.setError(err)
+			return result
+		}
+		result.setChanges(f.changeStatus(input, output), input, output)
+	}
+
+	if f.dryRun() {
+		if f.config.Diff {
+			f.reportChanges(result, input, output)
+		}
+		return result
+	}
+
+	if f.config.CursorOffset != nil {
+		// the new cursor offset is written on a line of its own before the
+		// code, in the same way as clang-format
+		_, err = fmt.Fprintf(f.stdout, "{\"cursor\": %d}\n", cursor)
+		if err != nil {
+			result.setError(fmt.Errorf("failed to write data: %w", err))
+			return result
+		}
+	}
+	_, err = f.stdout.Write(output)
 	if err != nil {
-		zap.S().Errorf("failed to format data: %s", err)
-		return err
+		result.setError(fmt.Errorf("failed to write data: %w", err))
+		return result
+	}
+	return result
+}
+
+// formatStdin formats source code read from stdin, using the project
+// configuration for the stdin file path, if one is configured. The offset of
+// the cursor in the output is also returned, if a cursor offset is configured.
+func (f *Formatter) formatStdin(input []byte) ([]byte, int, error) {
+	settings := f.settings
+	if f.config.StdinFilePath != "" {
+		var err error
+		settings, err = f.settingsFor(f.config.StdinFilePath)
+		if err != nil {
+			return nil, -1, err
+		}
+	}
+	cursor := -1
+	if f.config.CursorOffset != nil {
+		cursor = *f.config.CursorOffset
 	}
+	return formatSourceCursor(input, settings, cursor, zap.L())
+}
 
-	os.Stdout.Write(output)
+// isIgnored returns true if a file matches the ignore rules.
+func (f *Formatter) isIgnored(fileName string) (bool, error) {
+	rule, err := f.ignore.Match(fileName, false)
 	if err != nil {
-		zap.S().Errorf("failed to write data: %s", err)
-		return err
+		return false, err
 	}
+	if rule != nil {
+		zap.S().Infof("%s: ignored by %s", fileName, rule)
+		return true, nil
+	}
+	return false, nil
+}
 
+// dryRun returns true if the formatter should only report changes, rather
+// than writing them.
+func (f *Formatter) dryRun() bool {
+	return f.config.Check || f.config.Diff
+}
+
+// reportChanges sets the report for a file that would be reformatted, which
+// is either the file name or a diff. The report is written separately by
+// writeReport, so that reports are written in order when formatting in parallel.
+func (f *Formatter) reportChanges(result *FileResult, input []byte, output []byte) {
+	if result.Status != StatusWouldReformat {
+		return
+	}
+	if !f.config.Diff {
+		result.report = []byte(result.Path + "\n")
+		return
+	}
+	var buf bytes.Buffer
+	// diffs in JSON reports are never colored
+	d := &diffWriter{writer: &buf, color: f.colorDiffs && !f.config.JSON, base: f.diffBase(result.Path)}
+	err := d.writeDiff(result.Path, input, output)
+	if err != nil {
+		result.setError(fmt.Errorf("failed to write diff: %w", err))
+		return
+	}
+	result.report = buf.Bytes()
+}
+
+// writeReport writes the report for a file (if any) to stdout.
+func (f *Formatter) writeReport(result *FileResult) {
+	if len(result.report) == 0 {
+		return
+	}
+	_, err := f.stdout.Write(result.report)
+	if err != nil {
+		result.setError(fmt.Errorf("failed to write report: %w", err))
+	}
+}
+
+// changeStatus returns the status of a file, based on whether the formatted
+// output differs from the input.
+func (f *Formatter) changeStatus(input []byte, output []byte) FileStatus {
+	if bytes.Equal(input, output) {
+		return StatusUnchanged
+	}
+	if f.dryRun() {
+		return StatusWouldReformat
+	}
+	return StatusReformatted
+}
+
+// resolveOptions returns the project configuration options for a file.
+func (f *Formatter) resolveOptions(fileName string) (*config.ResolvedOptions, error) {
+	return f.projectConfig.Resolve(fileName, DefaultFormatSettings().options())
+}
+
+// settingsFor returns the format settings for a file, based on the project
+// configuration files that apply to it.
+func (f *Formatter) settingsFor(fileName string) (*FormatSettings, error) {
+	options, err := f.resolveOptions(fileName)
+	if err != nil {
+		return nil, err
+	}
+	settings := DefaultFormatSettings()
+	settings.apply(&options.FormatOptions)
+	settings.lines = f.config.Lines
+	if f.formatChangedOnly() {
+		settings.lines = f.changedLinesFor(fileName)
+	}
+	return settings, nil
+}
+
+// DumpConfig writes the effective project configuration options for each
+// target path to stdout, along with where each option was set.
+func (f *Formatter) DumpConfig() error {
+	targetPaths := f.config.TargetPaths
+	if len(targetPaths) == 0 {
+		return errors.New("config dump requires at least one file")
+	}
+	for i, targetPath := range targetPaths {
+		options, err := f.resolveOptions(targetPath)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			_, err = fmt.Fprintln(f.stdout)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintf(f.stdout, "# %s\n", targetPath)
+		if err != nil {
+			return err
+		}
+		err = options.Dump(f.stdout)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (f *Formatter) formatBytes(input []byte) ([]byte, error) {
-	zap.S().Debug("formatBytes")
+	return formatSource(input, f.settings, zap.L())
+}
+
+// FormatSource formats source code using the default settings, updated with the
+// options that are set. If lines is not empty, only the top level statements
+// that overlap the line ranges are reformatted. If cursor is not negative, the
+// offset in the output that corresponds to the byte offset cursor in the input
+// is also returned. Unlike Formatter, it does not access the filesystem or use
+// the global logger, so it is safe to call from library code.
+func FormatSource(input []byte, options *config.FormatOptions, lines []config.LineRange, cursor int, logger *zap.Logger) ([]byte, int, error) {
+	settings := DefaultFormatSettings()
+	settings.apply(options)
+	settings.lines = lines
+	return formatSourceCursor(input, settings, cursor, logger)
+}
+
+// FormatDocument formats the source code of a file that is open in an editor,
+// using the project configuration for the file's path. The file itself is not
+// read or written. If fileName is "", the default settings are used.
+func (f *Formatter) FormatDocument(fileName string, input []byte) ([]byte, error) {
+	settings := DefaultFormatSettings()
+	if fileName != "" {
+		// configuration files may be edited while the document is open, so
+		// they are loaded each time rather than cached
+		options, err := config.NewProjectConfigLoader().Resolve(fileName, DefaultFormatSettings().options())
+		if err != nil {
+			return nil, err
+		}
+		settings = DefaultFormatSettings()
+		settings.apply(&options.FormatOptions)
+	}
+	return formatSource(input, settings, zap.L())
+}
+
+// CheckSyntax parses source code, and returns any syntax errors.
+func CheckSyntax(input []byte, logger *zap.Logger) []*SyntaxError {
+	_, syntaxErrs := Parse(input, logger)
+	return syntaxErrs
+}
+
+// Parse parses source code, returning the parse tree and any syntax errors. If
+// there are syntax errors, the parse tree may be incomplete.
+func Parse(input []byte, logger *zap.Logger) (parser.IStartContext, []*SyntaxError) {
+	_, p, e := newParser(input, logger)
+	startContext := p.Start_()
+	return startContext, e.errs
+}
+
+// Tokenize splits source code into tokens, including the comments and
+// whitespace on the hidden channel. Unlike parsing, this doesn't depend on the
+// source being free of syntax errors.
+func Tokenize(input []byte, logger *zap.Logger) []antlr.Token {
+	tokens, _, _ := newParser(input, logger)
+	tokens.Fill()
+	return tokens.GetAllTokens()
+}
+
+// formatSource formats source code with the given settings. All state is local
+// to the call, so it may be called from many goroutines at once.
+func formatSource(input []byte, settings *FormatSettings, logger *zap.Logger) ([]byte, error) {
+	output, _, err := formatSourceCursor(input, settings, -1, logger)
+	return output, err
+}
+
+// formatSourceCursor formats source code like formatSource, and also returns
+// the offset in the output that corresponds to the byte offset cursor in the
+// input. If cursor is negative, it isn't tracked and -1 is returned.
+func formatSourceCursor(input []byte, settings *FormatSettings, cursor int, logger *zap.Logger) ([]byte, int, error) {
+	logger.Debug("formatSource")
+	tokens, p, e := newParser(input, logger)
+	outputBuffer := &bytes.Buffer{}
+	v := NewFormattingVisitor(tokens, settings, logger)
+	if cursor >= 0 {
+		v.cursor = newSourceCursor(input, cursor)
+	}
+
+	startContext := p.Start_()
+	if e.lastErr != nil {
+		return outputBuffer.Bytes(), -1, &SyntaxErrors{Errors: e.errs}
+	}
+	startContext.Accept(v)
+	if v.err != nil {
+		return outputBuffer.Bytes(), -1, v.err
+	}
+	printer := newPrinter(settings, outputBuffer, logger)
+	err := printer.print(v.doc.root())
+	if err == nil {
+		err = printer.finish()
+	}
+	if err != nil {
+		return outputBuffer.Bytes(), -1, err
+	}
+
+	output := outputBuffer.Bytes()
+	outputCursor := -1
+	if v.cursor != nil {
+		outputCursor = min(max(printer.cursorOffset, 0), len(output))
+	}
+	if len(settings.lines) > 0 {
+		output, outputCursor = formatLines(input, output, v.segments, settings.lines, v.cursor, outputCursor)
+	}
+	return output, outputCursor, nil
+}
+
+// newParser returns a parser for source code, with an error listener that
+// records syntax errors.
+func newParser(input []byte, logger *zap.Logger) (*antlr.CommonTokenStream, *parser.OpenSCADParser, *ErrorListener) {
 	antlrStream := antlr.NewIoStream(bytes.NewBuffer(input))
 	lexer := parser.NewOpenSCADLexer(antlrStream)
 	tokens := antlr.NewCommonTokenStream(lexer, antlr.TokenDefaultChannel)
 	p := parser.NewOpenSCADParser(tokens)
-	outputBuffer := &bytes.Buffer{}
-	formatter := NewTokenFormatter(f.settings, outputBuffer)
-	v := NewFormattingVisitor(tokens, formatter)
-	e := &ErrorListener{}
+
+	// replace the default listeners, which print errors to stderr
+	e := &ErrorListener{logger: logger}
+	lexer.RemoveErrorListeners()
+	lexer.AddErrorListener(e)
+	p.RemoveErrorListeners()
 	p.AddErrorListener(e)
-	startContext := p.Start_()
-	if e.lastErr == nil {
-		startContext.Accept(v)
-	}
-	return outputBuffer.Bytes(), e.lastErr
+	return tokens, p, e
 }
 
 func checkFile(sourceFile string) error {
@@ -29,6 +29,7 @@ import (
 	"github.com/hexops/gotextdiff"
 	"github.com/hexops/gotextdiff/myers"
 	"github.com/hexops/gotextdiff/span"
+	"github.com/hugheaves/scadformat/internal/config"
 	"github.com/hugheaves/scadformat/internal/logutil"
 )
 
@@ -51,7 +52,7 @@ func TestFormat(t *testing.T) {
 	runTestOnDir(t, validInputDir, func(t *testing.T) {
 		testData := readTestData(t, validInputDir)
 
-		formatter := NewFormatter("")
+		formatter := NewFormatter(&config.MainConfig{})
 
 		output, err := formatter.formatBytes(testData)
 		if err != nil {
@@ -72,7 +73,7 @@ func TestReformat(t *testing.T) {
 	runTestOnDir(t, validInputDir, func(t *testing.T) {
 		validInput := readTestData(t, validInputDir)
 
-		formatter := NewFormatter("")
+		formatter := NewFormatter(&config.MainConfig{})
 
 		output, err := formatter.formatBytes(validInput)
 		if err != nil {
@@ -102,7 +103,7 @@ func TestInvalid(t *testing.T) {
 	runTestOnDir(t, invalidInputDir, func(t *testing.T) {
 		testData := readTestData(t, invalidInputDir)
 
-		formatter := NewFormatter("")
+		formatter := NewFormatter(&config.MainConfig{})
 
 		_, err := formatter.formatBytes(testData)
 		if err == nil {
@@ -119,7 +120,7 @@ func TestUpdate(t *testing.T) {
 	runTestOnDir(t, validInputDir, func(t *testing.T) {
 		validInput := readTestData(t, validInputDir)
 
-		formatter := NewFormatter("")
+		formatter := NewFormatter(&config.MainConfig{})
 
 		output, err := formatter.formatBytes(validInput)
 		if err != nil {
@@ -19,11 +19,15 @@
 package formatter
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"reflect"
 	"regexp"
 	"strings"
 
 	"github.com/antlr4-go/antlr/v4"
+	"github.com/hugheaves/scadformat/internal/config"
 	"github.com/hugheaves/scadformat/internal/parser"
 	"go.uber.org/zap"
 )
@@ -36,16 +40,23 @@ var includeOrUseRegex = regexp.MustCompile("(include|use)[ \t]*(<[^\t\r\n>]*>)")
 type FormattingVisitor struct {
 	parser.BaseOpenSCADVisitor
 	tokenStream             antlr.TokenStream
-	formatter               *TokenFormatter
+	settings                *FormatSettings
+	doc                     *docBuilder
 	lastPrintedCommentIndex int
 	endLineAfterComma       bool
+	logger                  *zap.SugaredLogger
+	err                     error         // the first error that prevented the source from being formatted
+	segments                []segment     // the segments of the source containing the top level statements
+	cursor                  *sourceCursor // the cursor position to track, or nil
 }
 
-func NewFormattingVisitor(tokenStream antlr.TokenStream, formatter *TokenFormatter) *FormattingVisitor {
+func NewFormattingVisitor(tokenStream antlr.TokenStream, settings *FormatSettings, logger *zap.Logger) *FormattingVisitor {
 	visitor := &FormattingVisitor{
 		tokenStream:             tokenStream,
-		formatter:               formatter,
+		settings:                settings,
+		doc:                     newDocBuilder(),
 		lastPrintedCommentIndex: 0,
+		logger:                  logger.Sugar(),
 	}
 
 	// Override VisitChildren in BaseOpenClassVisitor
@@ -57,9 +68,9 @@ func NewFormattingVisitor(tokenStream antlr.TokenStream, formatter *TokenFormatt
 
 func (v *FormattingVisitor) Visit(tree antlr.ParseTree) interface{} {
 	if tree != nil {
-		zap.S().Debugf("Visiting: %s", reflect.TypeOf(tree).String())
+		v.logger.Debugf("Visiting: %s", reflect.TypeOf(tree).String())
 		tree.Accept(v)
-		zap.S().Debugf("Visited: %s", reflect.TypeOf(tree).String())
+		v.logger.Debugf("Visited: %s", reflect.TypeOf(tree).String())
 	}
 	return nil
 }
@@ -75,16 +86,25 @@ func (v *FormattingVisitor) VisitChildren(tree antlr.RuleNode) interface{} {
 func (v *FormattingVisitor) VisitTerminal(node antlr.TerminalNode) interface{} {
 
 	v.printCommentsBefore(node.GetSymbol().GetTokenIndex())
-	v.formatter.printString(node.GetText())
+	v.trackCursor(node.GetSymbol(), len(node.GetText()))
+	v.doc.printString(node.GetText())
 	v.printEndOfLineCommentAfter(node.GetSymbol().GetTokenIndex())
 	return nil
 }
 
 func (v *FormattingVisitor) VisitErrorNode(errorNode antlr.ErrorNode) interface{} {
-	zap.S().Fatalf("Unable to resolve parsing error: %s", errorNode.GetText())
+	v.fail(fmt.Errorf("unable to resolve parsing error: %s", errorNode.GetText()))
 	return nil
 }
 
+// fail records an error that prevents the source from being formatted. Only
+// the first error is kept.
+func (v *FormattingVisitor) fail(err error) {
+	if v.err == nil {
+		v.err = err
+	}
+}
+
 func (v *FormattingVisitor) VisitStart(ctx *parser.StartContext) interface{} {
 	// Visit only "input", not the EOF token
 	v.Visit(ctx.Input())
@@ -92,59 +112,86 @@ func (v *FormattingVisitor) VisitStart(ctx *parser.StartContext) interface{} {
 	return nil
 }
 
+// VisitInput visits the top level statements, recording the segment of the
+// source and output that contains each one.
+func (v *FormattingVisitor) VisitInput(ctx *parser.InputContext) interface{} {
+	for _, child := range ctx.GetChildren() {
+		v.Visit(child.(antlr.ParseTree))
+		if statement, ok := child.(antlr.ParserRuleContext); ok {
+			v.endSegment(statement)
+		}
+	}
+	return nil
+}
+
 func (v *FormattingVisitor) VisitAssignment(ctx *parser.AssignmentContext) interface{} {
 	v.Visit(ctx.ID())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.EQUALS())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.Expr())
 	v.Visit(ctx.SEMICOLON())
-	v.formatter.endLine()
+	v.doc.endLine()
 	return nil
 }
 
 func (v *FormattingVisitor) VisitAssignmentExpression(ctx *parser.AssignmentExpressionContext) interface{} {
 	v.Visit(ctx.ID())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.EQUALS())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.Expr())
 	return nil
 }
 
+// VisitBinaryExpr formats a chain of binary operators as a group. If the
+// group is broken, the chain is broken after every operator, and the
+// continuation lines are indented.
 func (v *FormattingVisitor) VisitBinaryExpr(ctx *parser.BinaryExprContext) interface{} {
-	v.Visit(ctx.Expr(0))
-	v.formatter.printSpace()
-	v.Visit(ctx.BinaryOperator())
-	v.formatter.printSpace()
-	v.Visit(ctx.Expr(1))
+	operands, operators := binaryChain(ctx)
+	v.doc.group(v.mustBreak(ctx), func() {
+		v.Visit(operands[0])
+		v.doc.indentIfBreak(func() {
+			for i, operator := range operators {
+				v.doc.printSpace()
+				v.Visit(operator)
+				v.doc.line()
+				v.Visit(operands[i+1])
+			}
+		})
+	})
 	return nil
 }
 
+// VisitTernaryExpr formats a ternary expression as a group. If the group is
+// broken, the "?" and ":" branches start indented continuation lines.
 func (v *FormattingVisitor) VisitTernaryExpr(ctx *parser.TernaryExprContext) interface{} {
-	v.Visit(ctx.Expr(0))
-	v.formatter.printSpace()
-	v.Visit(ctx.QUESTION_MARK())
-	v.formatter.printSpace()
-	v.Visit(ctx.Expr(1))
-	v.formatter.printSpace()
-	v.Visit(ctx.COLON())
-	v.formatter.printSpace()
-	v.Visit(ctx.Expr(2))
+	v.doc.group(v.mustBreak(ctx), func() {
+		v.Visit(ctx.Expr(0))
+		v.doc.indentIfBreak(func() {
+			v.doc.line()
+			v.Visit(ctx.QUESTION_MARK())
+			v.doc.printSpace()
+			v.Visit(ctx.Expr(1))
+			v.doc.line()
+			v.Visit(ctx.COLON())
+			v.doc.printSpace()
+			v.Visit(ctx.Expr(2))
+		})
+	})
 	return nil
-
 }
 
 func (v *FormattingVisitor) VisitChildStatements(ctx *parser.ChildStatementsContext) interface{} {
 	v.Visit(ctx.L_CURLY())
-	v.formatter.endLine()
+	v.doc.endLine()
 	for _, child := range ctx.AllChildStatementOrAssignment() {
 		if child.Assignment() != nil {
-			v.formatter.indent()
+			v.doc.indent()
 		}
 		v.Visit(child)
 		if child.Assignment() != nil {
-			v.formatter.unindent()
+			v.doc.unindent()
 		}
 	}
 	v.Visit(ctx.R_CURLY())
@@ -153,95 +200,138 @@ func (v *FormattingVisitor) VisitChildStatements(ctx *parser.ChildStatementsCont
 
 func (v *FormattingVisitor) VisitSemicolon(ctx *parser.SemicolonContext) interface{} {
 	v.Visit(ctx.SEMICOLON())
-	v.formatter.endLine()
+	v.doc.endLine()
 	return nil
 }
 
 func (v *FormattingVisitor) VisitFunctionDefinition(ctx *parser.FunctionDefinitionContext) interface{} {
 	v.Visit(ctx.FUNCTION())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.ID())
-	v.Visit(ctx.L_PAREN())
-	v.Visit(ctx.Parameters())
-	v.Visit(ctx.R_PAREN())
-	v.formatter.printSpace()
+	v.printParameters(ctx.L_PAREN(), ctx.Parameters(), ctx.R_PAREN())
+	v.doc.printSpace()
 	v.Visit(ctx.EQUALS())
-	v.formatter.endLine()
-	v.formatter.indent()
-	v.Visit(ctx.Expr())
-	v.Visit(ctx.SEMICOLON())
-	v.formatter.endLine()
-	v.formatter.unindent()
+	v.printFunctionBody(ctx.EQUALS(), ctx.Expr(), ctx.SEMICOLON(), false)
+	v.doc.endLine()
+	return nil
+}
+
+func (v *FormattingVisitor) VisitFunctionLiteralExpr(ctx *parser.FunctionLiteralExprContext) interface{} {
+	v.Visit(ctx.FUNCTION())
+	v.printParameters(ctx.L_PAREN(), ctx.Parameters(), ctx.R_PAREN())
+	v.printFunctionBody(ctx.R_PAREN(), ctx.Expr(), nil, true)
 	return nil
 }
 
+// printFunctionBody formats the body of a function definition or literal,
+// followed by end (if not nil). The body either follows the text before it on
+// the same line, or starts an indented line, depending on the function body
+// style. With the "break" style, the bodies of literals only start a new line
+// when there is a maximum line length, so that literals stay on one line by
+// default. Bodies that follow a comment, and let and assert expressions (which
+// always span several lines), always start a new line.
+func (v *FormattingVisitor) printFunctionBody(start antlr.ParseTree, body parser.IExprContext, end antlr.ParseTree, literal bool) {
+	shouldBreak := false
+	switch body.(type) {
+	case *parser.LetExprContext, *parser.AssertExprContext:
+		shouldBreak = true
+	}
+	switch v.settings.functionBodyStyle {
+	case config.FunctionBodyBreak:
+		shouldBreak = shouldBreak || !literal || v.settings.maxLineLen != math.MaxInt
+	case config.FunctionBodyBreakComplex:
+		if _, ok := body.(*parser.TernaryExprContext); ok {
+			shouldBreak = true
+		}
+	}
+
+	if shouldBreak || v.containsHidden(start, body) {
+		// a hard line break, so the groups containing a literal are broken too
+		v.doc.endLine()
+		v.doc.indent()
+		v.Visit(body)
+		v.Visit(end)
+		v.doc.unindent()
+		return
+	}
+	v.doc.group(false, func() {
+		v.doc.indentIfBreak(func() {
+			v.doc.line()
+			v.Visit(body)
+			v.Visit(end)
+		})
+	})
+}
+
 func (v *FormattingVisitor) VisitModuleDefinition(ctx *parser.ModuleDefinitionContext) interface{} {
 	v.Visit(ctx.MODULE())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.ID())
-	v.Visit(ctx.L_PAREN())
-	v.Visit(ctx.Parameters())
-	v.Visit(ctx.R_PAREN())
-	v.formatter.printSpace()
+	v.printParameters(ctx.L_PAREN(), ctx.Parameters(), ctx.R_PAREN())
+	if ctx.Statement().Statements() != nil {
+		v.printBeforeBrace(v.settings.moduleBraceStyle)
+	} else {
+		v.doc.printSpace()
+	}
 	v.Visit(ctx.Statement())
 	return nil
 }
 
 func (v *FormattingVisitor) VisitAssertExpr(ctx *parser.AssertExprContext) interface{} {
 	v.Visit(ctx.ASSERT())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.ParenArgs())
 	if ctx.Expr() != nil {
-		v.formatter.endLine()
-		v.formatter.indent()
+		v.doc.endLine()
+		v.doc.indent()
 		v.Visit(ctx.Expr())
-		v.formatter.unindent()
+		v.doc.unindent()
 	}
 	return nil
 }
 
 func (v *FormattingVisitor) VisitEchoExpr(ctx *parser.EchoExprContext) interface{} {
 	v.Visit(ctx.ECHO())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.ParenArgs())
 	if ctx.Expr() != nil {
-		v.formatter.endLine()
-		v.formatter.indent()
+		v.doc.endLine()
+		v.doc.indent()
 		v.Visit(ctx.Expr())
-		v.formatter.unindent()
+		v.doc.unindent()
 	}
 	return nil
 }
 
 func (v *FormattingVisitor) VisitLetExpr(ctx *parser.LetExprContext) interface{} {
 	v.Visit(ctx.LET())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.ParenArgs())
-	v.formatter.endLine()
-	v.formatter.indent()
+	v.doc.endLine()
+	v.doc.indent()
 	v.Visit(ctx.Expr())
-	v.formatter.unindent()
+	v.doc.unindent()
 	return nil
 }
 
 func (v *FormattingVisitor) VisitSingleModuleInstantiation(ctx *parser.SingleModuleInstantiationContext) interface{} {
 	v.VisitChildren(ctx)
-	v.formatter.endLine()
+	v.doc.endLine()
 	return nil
 }
 
 func (v *FormattingVisitor) VisitStatements(ctx *parser.StatementsContext) interface{} {
 	v.Visit(ctx.L_CURLY())
-	v.formatter.endLine()
-	v.formatter.indent()
+	v.doc.endLine()
+	v.doc.indent()
 	for _, childCtx := range ctx.GetChildren() {
 		if _, ok := childCtx.(parser.IStatementContext); ok {
 			v.Visit(childCtx.(antlr.RuleContext))
 		}
 	}
-	v.formatter.unindent()
+	v.doc.unindent()
 	v.Visit(ctx.R_CURLY())
-	v.formatter.endLine()
+	v.doc.endLine()
 	return nil
 }
 
@@ -253,12 +343,14 @@ func (v *FormattingVisitor) VisitIncludeOrUseFile(ctx *parser.IncludeOrUseFileCo
 	v.printCommentsBefore(ctx.GetStart().GetTokenIndex())
 	matches := includeOrUseRegex.FindStringSubmatch(ctx.INCLUDE_OR_USE_FILE().GetText())
 	if len(matches) != 3 {
-		zap.S().Fatal("Failed to parse the include or use statement: %s", ctx.GetText())
+		v.fail(fmt.Errorf("failed to parse the include or use statement: %s", ctx.GetText()))
+		return nil
 	}
-	v.formatter.printString(matches[1])
-	v.formatter.printSpace()
-	v.formatter.printString(matches[2])
-	v.formatter.endLine()
+	v.trackCursor(ctx.GetStart(), len(matches[1]))
+	v.doc.printString(matches[1])
+	v.doc.printSpace()
+	v.doc.printString(matches[2])
+	v.doc.endLine()
 	return nil
 }
 
@@ -286,33 +378,15 @@ func (v *FormattingVisitor) VisitVector(ctx *parser.VectorContext) interface{} {
 			}
 		}
 	}
-	v.Visit(ctx.L_BRACKET())
-	if nested {
-		v.formatter.endLine()
-		v.formatter.indent()
-	}
-
-	for i, ve := range allVectorElements {
-		v.Visit(ve)
-		if ctx.Comma(i) != nil {
-			v.Visit(ctx.Comma(i))
-		}
-		if nested {
-			v.formatter.endLine()
-		} else if i < len(allVectorElements)-1 {
-			v.formatter.printSpace()
-		}
-	}
-	if nested {
-		v.formatter.unindent()
-	}
-	v.Visit(ctx.R_BRACKET())
+	v.printGroup(ctx.L_BRACKET(), parseTrees(allVectorElements), func(i int) antlr.ParseTree {
+		return ctx.Comma(i)
+	}, ctx.R_BRACKET(), nested || v.mustBreak(ctx))
 	return nil
 }
 
 func (v *FormattingVisitor) VisitForStatementComprehension(ctx *parser.ForStatementComprehensionContext) interface{} {
 	v.Visit(ctx.FOR())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.L_PAREN())
 	v.Visit(ctx.Arguments(0))
 	if ctx.SEMICOLON(0) != nil {
@@ -322,46 +396,46 @@ func (v *FormattingVisitor) VisitForStatementComprehension(ctx *parser.ForStatem
 		v.Visit(ctx.Arguments(1))
 	}
 	v.Visit(ctx.R_PAREN())
-	v.formatter.endLine()
-	v.formatter.indent()
+	v.doc.endLine()
+	v.doc.indent()
 	v.Visit(ctx.VectorElement())
-	v.formatter.unindent()
+	v.doc.unindent()
 	return nil
 }
 
 func (v *FormattingVisitor) VisitLetStatementComprehension(ctx *parser.LetStatementComprehensionContext) interface{} {
 	v.Visit(ctx.LET())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.ParenArgs())
-	v.formatter.endLine()
-	v.formatter.indent()
+	v.doc.endLine()
+	v.doc.indent()
 	v.Visit(ctx.ListComprehensionElementsP())
-	v.formatter.unindent()
+	v.doc.unindent()
 	return nil
 }
 
 func (v *FormattingVisitor) VisitEachStatementComprehension(ctx *parser.EachStatementComprehensionContext) interface{} {
 	v.Visit(ctx.EACH())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.VectorElement())
 	return nil
 }
 
 func (v *FormattingVisitor) VisitIfStatementComprehension(ctx *parser.IfStatementComprehensionContext) interface{} {
 	v.Visit(ctx.IF())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.ParenExpr())
-	v.formatter.endLine()
-	v.formatter.indent()
+	v.doc.endLine()
+	v.doc.indent()
 	v.Visit(ctx.VectorElement(0))
-	v.formatter.unindent()
+	v.doc.unindent()
 	if ctx.ELSE() != nil {
-		v.formatter.endLine()
+		v.doc.endLine()
 		v.Visit(ctx.ELSE())
-		v.formatter.endLine()
-		v.formatter.indent()
+		v.doc.endLine()
+		v.doc.indent()
 		v.Visit(ctx.VectorElement(1))
-		v.formatter.unindent()
+		v.doc.unindent()
 	}
 	return nil
 }
@@ -369,17 +443,18 @@ func (v *FormattingVisitor) VisitIfStatementComprehension(ctx *parser.IfStatemen
 func (v *FormattingVisitor) VisitIfElseStatement(ctx *parser.IfElseStatementContext) interface{} {
 	v.Visit(ctx.IfStatement())
 	if ctx.ELSE() != nil {
-		v.formatter.printSpace()
+		// "else" follows the closing brace of the if with the K&R style
+		v.printBeforeBrace(v.settings.controlBraceStyle)
 		v.Visit(ctx.ELSE())
 		v.Visit(ctx.ChildStatement())
 	}
-	v.formatter.endLine()
+	v.doc.endLine()
 	return nil
 }
 
 func (v *FormattingVisitor) VisitIfStatement(ctx *parser.IfStatementContext) interface{} {
 	v.Visit(ctx.IF())
-	v.formatter.printSpace()
+	v.doc.printSpace()
 	v.Visit(ctx.ParenExpr())
 	v.Visit(ctx.ChildStatement())
 	return nil
@@ -387,18 +462,18 @@ func (v *FormattingVisitor) VisitIfStatement(ctx *parser.IfStatementContext) int
 
 // func (v *FormattingVisitor) VisitComma(ctx *parser.CommaContext) interface{} {
 // 	v.Visit(ctx.COMMA())
-// 	v.formatter.printSpace()
+// 	v.doc.printSpace()
 // 	return nil
 // }
 
 // Formats a childStatement, which can be a semicolon, a moduleInstantiation, or a childStatements node.
 func (v *FormattingVisitor) VisitChildStatement(ctx *parser.ChildStatementContext) interface{} {
-	zap.S().Debugf("formatChildStatement: parent=%s", reflect.TypeOf(ctx.GetParent()))
+	v.logger.Debugf("formatChildStatement: parent=%s", reflect.TypeOf(ctx.GetParent()))
 
 	if ctx.Semicolon() != nil {
 		v.Visit(ctx.Semicolon())
 	} else if ctx.ChildStatements() != nil {
-		v.formatter.printSpace()
+		v.printBeforeBrace(v.childBraceStyle(ctx))
 		v.Visit(ctx.ChildStatements())
 	} else if ctx.ModuleInstantiation() != nil {
 		_, parentIsIfElse := ctx.GetParent().(*parser.IfElseStatementContext)
@@ -406,50 +481,85 @@ func (v *FormattingVisitor) VisitChildStatement(ctx *parser.ChildStatementContex
 		elseIf := ctx.ModuleInstantiation().IfElseStatement() != nil && parentIsIfElse
 		// special formatting for else-if statements: don't start a new line before the "if"
 		if !elseIf {
-			v.formatter.endLine()
-			v.formatter.indent()
+			v.doc.endLine()
+			v.doc.indent()
 		} else {
-			v.formatter.printSpace()
+			v.doc.printSpace()
 		}
 		v.Visit(ctx.ModuleInstantiation())
 		if !elseIf {
-			v.formatter.unindent()
+			v.doc.unindent()
 		}
 	} else {
 		// not possible to hit this unless there's a parser bug
-		zap.S().Fatalf("Invalid child statement state")
+		v.fail(errors.New("invalid child statement state"))
+	}
+	return nil
+}
+
+// printBeforeBrace separates an opening brace from the text before it, which is
+// a space with the K&R style, or a line break with the Allman style.
+func (v *FormattingVisitor) printBeforeBrace(style string) {
+	if style == config.BraceStyleAllman {
+		v.doc.endLine()
+	} else {
+		v.doc.printSpace()
+	}
+}
+
+// childBraceStyle returns the brace style for the children of a module
+// instantiation, which depends on whether it is control flow (if, else or
+// for) or another module.
+func (v *FormattingVisitor) childBraceStyle(ctx *parser.ChildStatementContext) string {
+	switch parent := ctx.GetParent().(type) {
+	case *parser.IfStatementContext, *parser.IfElseStatementContext, *parser.ForStatementContext:
+		return v.settings.controlBraceStyle
+	case *parser.SingleModuleInstantiationContext:
+		if parent.ModuleId().FOR() != nil {
+			return v.settings.controlBraceStyle
+		}
 	}
+	return v.settings.childrenBraceStyle
+}
+
+// VisitParenArgs formats the arguments of a call or module instantiation as a
+// group.
+func (v *FormattingVisitor) VisitParenArgs(ctx *parser.ParenArgsContext) interface{} {
+	v.printArguments(ctx.L_PAREN(), ctx.Arguments(), ctx.R_PAREN(), v.mustBreak(ctx))
 	return nil
 }
 
 func (v *FormattingVisitor) VisitArguments(ctx *parser.ArgumentsContext) interface{} {
+	v.printArguments(nil, ctx, nil, false)
+	return nil
+}
+
+func (v *FormattingVisitor) printArguments(open antlr.TerminalNode, ctx parser.IArgumentsContext, close antlr.TerminalNode, shouldBreak bool) {
 	allArgs := ctx.AllArgument()
-	for i, arg := range allArgs {
-		v.Visit(arg)
-		if ctx.Comma(i) != nil {
-			v.Visit(ctx.Comma(i))
-		}
+	v.printGroup(open, parseTrees(allArgs), func(i int) antlr.ParseTree {
 		if i < len(allArgs)-1 {
-			v.formatter.printSpace()
+			return ctx.Comma(i)
 		}
-	}
-	v.Visit(ctx.OptionalTrailingComma())
-	return nil
+		return ctx.OptionalTrailingComma()
+	}, close, shouldBreak)
 }
 
 func (v *FormattingVisitor) VisitParameters(ctx *parser.ParametersContext) interface{} {
+	v.printParameters(nil, ctx, nil)
+	return nil
+}
+
+// printParameters formats the parameters of a module or function definition,
+// which are a group if they are between parentheses.
+func (v *FormattingVisitor) printParameters(open antlr.TerminalNode, ctx parser.IParametersContext, close antlr.TerminalNode) {
 	allParams := ctx.AllParameter()
-	for i, arg := range allParams {
-		v.Visit(arg)
-		if ctx.Comma(i) != nil {
-			v.Visit(ctx.Comma(i))
-		}
+	shouldBreak := open != nil && v.mustBreak(open, ctx, close)
+	v.printGroup(open, parseTrees(allParams), func(i int) antlr.ParseTree {
 		if i < len(allParams)-1 {
-			v.formatter.printSpace()
+			return ctx.Comma(i)
 		}
-	}
-	v.Visit(ctx.OptionalTrailingComma())
-	return nil
+		return ctx.OptionalTrailingComma()
+	}, close, shouldBreak)
 }
 
 func (v *FormattingVisitor) printEndOfLineCommentAfter(tokenIndex int) {
@@ -476,65 +586,65 @@ func (v *FormattingVisitor) printCommentsBefore(tokenIndex int) {
 func (v *FormattingVisitor) printCommentToken(token antlr.Token) {
 	switch tokenType := token.GetTokenType(); tokenType {
 	case parser.OpenSCADLexerEND_OF_LINE_COMMENT:
-		zap.S().Debugf("Printing END_OF_LINE_COMMENT: token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
+		v.logger.Debugf("Printing END_OF_LINE_COMMENT: token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
 		v.printEndOfLineComment(token)
 	case parser.OpenSCADLexerSINGLE_LINE_COMMENT:
-		zap.S().Debugf("Printing SINGLE_LINE_COMMENT, token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
+		v.logger.Debugf("Printing SINGLE_LINE_COMMENT, token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
 		v.printSingleLineComment(token)
 	case parser.OpenSCADLexerEND_OF_LINE_COMMENT_BLOCK:
-		zap.S().Debugf("Printing END_OF_LINE_COMMENT_BLOCK: token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
+		v.logger.Debugf("Printing END_OF_LINE_COMMENT_BLOCK: token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
 		v.printEndOfLineComment(token)
 	case parser.OpenSCADLexerSINGLE_LINE_COMMENT_BLOCK:
-		zap.S().Debugf("Printing SINGLE_LINE_COMMENT_BLOCK, token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
+		v.logger.Debugf("Printing SINGLE_LINE_COMMENT_BLOCK, token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
 		v.printSingleLineComment(token)
 	case parser.OpenSCADLexerMULTILINE_COMMENT_BLOCK:
-		zap.S().Debugf("Printing MULTILINE_COMMENT_BLOCK, token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
+		v.logger.Debugf("Printing MULTILINE_COMMENT_BLOCK, token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
 		v.printMultilineComment(token)
 	case parser.OpenSCADLexerMULTI_NEWLINE:
-		zap.S().Debugf("Printing MULTI_NEWLINE, token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
+		v.logger.Debugf("Printing MULTI_NEWLINE, token index = %d, text=[%s]", token.GetTokenIndex(), token.GetText())
 		v.printMultiNewlineComment(token.GetText())
 	default:
-		zap.S().Debugf("skipping non-comment token, token index = %d [%s]", token.GetTokenIndex(), token.GetText())
+		v.logger.Debugf("skipping non-comment token, token index = %d [%s]", token.GetTokenIndex(), token.GetText())
 	}
 }
 
 func (v *FormattingVisitor) printMultiNewlineComment(text string) {
 	newLineCount := strings.Count(text, "\n")
 	if newLineCount > 0 {
-		v.formatter.endLine()
+		v.doc.endLine()
 	}
 	for i := 1; i < newLineCount; i++ {
-		v.formatter.printNewLine()
+		v.doc.printNewLine()
 	}
 }
 
 // TO DO Implement re-formatting options for multiline comments.
-func (v *FormattingVisitor) printMultilineComment(token antlr.Token) error {
+func (v *FormattingVisitor) printMultilineComment(token antlr.Token) {
+	v.trackCursor(token, len(token.GetText()))
 	lines := strings.Split(token.GetText(), "\n")
-	for _, line := range lines {
-		err := v.formatter.appendToLine(line, false)
-		if err != nil {
-			return err
-		}
-		err = v.formatter.printNewLine()
-		if err != nil {
-			return err
+	v.doc.trailing(func() {
+		for _, line := range lines {
+			v.doc.appendToLine(line)
+			v.doc.printNewLine()
 		}
-	}
-	v.formatter.endLine()
-	return nil
+	})
+	v.doc.endLine()
 }
 
 func (v *FormattingVisitor) printSingleLineComment(token antlr.Token) {
-	v.formatter.endLine()
-	v.formatter.printString(strings.TrimSpace(token.GetText()))
-	v.formatter.endLine()
+	v.doc.endLine()
+	text := strings.TrimSpace(token.GetText())
+	v.trackCursor(token, len(text))
+	v.doc.printString(text)
+	v.doc.endLine()
 }
 
 func (v *FormattingVisitor) printEndOfLineComment(token antlr.Token) {
-	if v.formatter.inLine {
-		v.formatter.printSpace()
-	}
-	v.formatter.printString(strings.TrimSpace(token.GetText()))
-	v.formatter.endLine()
+	v.doc.trailing(func() {
+		v.doc.printSpace()
+		text := strings.TrimSpace(token.GetText())
+		v.trackCursor(token, len(text))
+		v.doc.printString(text)
+	})
+	v.doc.endLine()
 }
@@ -0,0 +1,211 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/hugheaves/scadformat/internal/gitdiff"
+	"go.uber.org/zap"
+)
+
+// pre-commit hook modes
+const (
+	HookModeFix   = "fix"   // format staged files, and stage the formatted code
+	HookModeCheck = "check" // fail with a diff if staged files are not formatted
+)
+
+// hookMarker identifies pre-commit hooks written by InstallHook, which can be
+// safely replaced.
+const hookMarker = "# installed by scadformat hook install"
+
+// InstallHook writes a git pre-commit hook that runs "scadformat hook run" in
+// the configured hook mode. The hook runs the scadformat found in the PATH when
+// committing, rather than this executable, which may be a temporary build (e.g.
+// from "go run") or be moved by an upgrade. An existing pre-commit hook is only
+// replaced if it was also installed by SCADFormat.
+func (f *Formatter) InstallHook() error {
+	mode := f.config.HookMode
+	err := checkHookMode(mode)
+	if err != nil {
+		return err
+	}
+	hooksDir, err := gitdiff.HooksDir(".")
+	if err != nil {
+		return err
+	}
+	hookPath := filepath.Join(hooksDir, "pre-commit")
+	existing, err := os.ReadFile(hookPath)
+	if err == nil && !bytes.Contains(existing, []byte(hookMarker)) {
+		return fmt.Errorf("%s already exists: remove it, or add \"scadformat hook run --hook-mode %s\" to it", hookPath, mode)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if _, err := exec.LookPath("scadformat"); err != nil {
+		zap.S().Warnf("scadformat is not in the PATH, which the pre-commit hook needs to run it")
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+%s
+if ! command -v scadformat >/dev/null 2>&1; then
+	echo "pre-commit: scadformat is not in the PATH" >&2
+	exit 1
+fi
+exec scadformat hook run --hook-mode %s
+`, hookMarker, mode)
+	err = os.MkdirAll(hooksDir, 0777)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(hookPath, []byte(script), 0777)
+	if err != nil {
+		return err
+	}
+	zap.S().Infof("installed pre-commit hook %s (mode %s)", hookPath, mode)
+	return nil
+}
+
+func checkHookMode(mode string) error {
+	if mode != HookModeFix && mode != HookModeCheck {
+		return fmt.Errorf("invalid hook mode %q: must be %q or %q", mode, HookModeFix, HookModeCheck)
+	}
+	return nil
+}
+
+// FormatStaged formats the staged content of the .scad files with changes
+// staged for the next commit. The staged content is formatted rather than the
+// working tree files, as they may contain changes that are not staged.
+//
+// In the "check" hook mode, check and diff mode are enabled, so a diff is
+// printed for each file that is not formatted. Otherwise, the formatted code is
+// staged, and written to the working tree file. A file that needs formatting
+// but also has unstaged changes is left as it is, and reported as an error. In
+// JSON mode, a JSON report of the results is printed instead of the diffs.
+func (f *Formatter) FormatStaged() (*Summary, error) {
+	err := checkHookMode(f.config.HookMode)
+	if err != nil {
+		return nil, err
+	}
+	f.config.Check = f.config.HookMode == HookModeCheck
+	f.config.Diff = f.config.Check
+
+	files, err := gitdiff.StagedFiles(".")
+	if err != nil {
+		return nil, err
+	}
+	root, err := gitdiff.Toplevel(".")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		f.addRepoRoot(file.Path, root)
+	}
+
+	summary := NewSummary()
+	var results []*FileResult
+	for _, file := range files {
+		if !isSourceFile(file.Path) {
+			continue
+		}
+		rule, err := f.ignore.Match(file.Path, false)
+		if err == nil && rule != nil {
+			zap.S().Debugf("skipping %s: ignored by %s", file.Path, rule)
+			continue
+		}
+		var result *FileResult
+		if err != nil {
+			result = &FileResult{Path: relativePath(file.Path)}
+			result.setError(err)
+		} else {
+			start := time.Now()
+			result = f.formatStagedFile(file)
+			result.Elapsed = time.Since(start)
+		}
+		if f.config.JSON {
+			results = append(results, result)
+		} else {
+			f.writeReport(result)
+		}
+		result.log()
+		summary.Add(result)
+	}
+
+	if summary.Count(StatusWouldReformat) > 0 {
+		zap.S().Errorf("staged files are not formatted: run scadformat on them, and stage the changes")
+	}
+	return summary, f.writeJSONReport(results, summary)
+}
+
+func (f *Formatter) formatStagedFile(file gitdiff.StagedFile) *FileResult {
+	result := &FileResult{Path: relativePath(file.Path)}
+	input, err := gitdiff.ReadStaged(file)
+	if err != nil {
+		result.setError(err)
+		return result
+	}
+
+	settings, err := f.settingsFor(file.Path)
+	if err != nil {
+		result.setError(err)
+		return result
+	}
+	output, err := formatSource(input, settings, zap.L())
+	if err != nil {
+		result.setError(err)
+		return result
+	}
+
+	result.output = output
+	result.setChanges(f.changeStatus(input, output), input, output)
+	if f.dryRun() {
+		f.reportChanges(result, input, output)
+		return result
+	}
+	if result.Status == StatusUnchanged {
+		return result
+	}
+
+	// the formatted code can't be staged if the file has unstaged changes, as
+	// the unformatted code in the working tree would undo the formatting when
+	// it is staged for the next commit
+	working, err := os.ReadFile(file.Path)
+	if err != nil || !bytes.Equal(working, input) {
+		result.setError(errors.New("the file has unstaged changes, so can't be formatted: stage or stash them, or format the file and stage it"))
+		return result
+	}
+
+	err = gitdiff.UpdateStaged(file, output)
+	if err != nil {
+		result.setError(err)
+		return result
+	}
+	// the original content is in git, so no backup is needed
+	err = writeFile(file.Path, output, f.config.PreserveTimestamp)
+	if err != nil {
+		result.setError(fmt.Errorf("failed to write file: %w", err))
+	}
+	return result
+}
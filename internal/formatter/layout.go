@@ -0,0 +1,130 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"math"
+	"slices"
+
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/hugheaves/scadformat/internal/parser"
+)
+
+// Lists (arguments, parameters and vectors), chains of binary operators and
+// ternary expressions are groups, which are printed on a single line if they
+// fit within the maximum line length, and are otherwise broken over several
+// lines. The layout only depends on the parse tree, so formatting the output
+// again doesn't change it.
+
+// mustBreak returns true if the parse trees, which form a group, contain
+// comments or blank lines, so the group can't be printed on a single line.
+// Groups are only broken when there is a maximum line length.
+func (v *FormattingVisitor) mustBreak(trees ...antlr.ParseTree) bool {
+	return v.settings.maxLineLen != math.MaxInt && v.containsHidden(trees...)
+}
+
+// containsHidden returns true if the parse trees contain comments or blank
+// lines.
+func (v *FormattingVisitor) containsHidden(trees ...antlr.ParseTree) bool {
+	if len(trees) == 0 {
+		return false
+	}
+	start := trees[0].GetSourceInterval().Start
+	stop := trees[len(trees)-1].GetSourceInterval().Stop
+	for i := start; i <= stop; i++ {
+		if v.tokenStream.Get(i).GetChannel() != antlr.TokenDefaultChannel {
+			return true
+		}
+	}
+	return false
+}
+
+// printGroup prints a list of items, each followed by its comma (if any),
+// between the open and close delimiters. Items are separated by spaces, or if
+// the group is broken, each item is printed on a line of its own, with an
+// extra level of indentation, and the closing delimiter starts a new line. The
+// delimiters may be nil, for lists without delimiters, which are never broken.
+func (v *FormattingVisitor) printGroup(open antlr.TerminalNode, items []antlr.ParseTree, comma func(i int) antlr.ParseTree, close antlr.TerminalNode, shouldBreak bool) {
+	if open == nil || len(items) == 0 {
+		v.Visit(open)
+		for i, item := range items {
+			v.Visit(item)
+			v.Visit(comma(i))
+			if i < len(items)-1 {
+				v.doc.printSpace()
+			}
+		}
+		v.Visit(close)
+		return
+	}
+
+	v.doc.group(shouldBreak, func() {
+		v.Visit(open)
+		list := v.doc.capture(func() {
+			for i, item := range items {
+				v.Visit(item)
+				v.Visit(comma(i))
+				if i < len(items)-1 {
+					v.doc.line()
+				}
+			}
+		})
+		// comments before the closing delimiter are indented with the items
+		comments := v.doc.capture(func() {
+			v.printCommentsBefore(close.GetSymbol().GetTokenIndex() - 1)
+		})
+		v.doc.add(docIfBreak{
+			broken: docIndent{contents: docConcat{docLine{soft: true}, list, docHardLine{}, comments}},
+			flat:   docConcat{list, comments},
+		})
+		v.doc.softLine()
+		v.Visit(close)
+	})
+}
+
+// parseTrees converts a slice of parse tree nodes to a slice of parse trees.
+func parseTrees[T antlr.ParseTree](nodes []T) []antlr.ParseTree {
+	trees := make([]antlr.ParseTree, len(nodes))
+	for i, node := range nodes {
+		trees[i] = node
+	}
+	return trees
+}
+
+// binaryChain returns the operands and operators of a chain of binary
+// operators. All binary operators have the same precedence in the grammar, so
+// the chain is the left hand side of the expression, followed by each of the
+// operators and right hand sides that were applied to it.
+func binaryChain(ctx *parser.BinaryExprContext) ([]antlr.ParseTree, []antlr.ParseTree) {
+	var operands, operators []antlr.ParseTree
+	var expr parser.IExprContext = ctx
+	for {
+		binary, ok := expr.(*parser.BinaryExprContext)
+		if !ok {
+			break
+		}
+		operands = append(operands, binary.Expr(1))
+		operators = append(operators, binary.BinaryOperator())
+		expr = binary.Expr(0)
+	}
+	operands = append(operands, expr)
+	slices.Reverse(operands)
+	slices.Reverse(operators)
+	return operands, operators
+}
@@ -0,0 +1,129 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestLineWrapping(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"cube([10, 20, 30], center = true);\n",
+			"cube([10, 20, 30], center = true);\n",
+		},
+		{
+			"cube(size = [width, height, depth], center = true);\n",
+			"cube(\n  size = [width, height, depth],\n  center = true\n);\n",
+		},
+		{
+			"module box(width = 10, height = 20, depth = 30) { cube(1); }\n",
+			"module box(\n  width = 10,\n  height = 20,\n  depth = 30\n) {\n  cube(1);\n}\n",
+		},
+		{
+			"points = [first_point, second_point, third_point, fourth];\n",
+			"points = [\n  first_point,\n  second_point,\n  third_point,\n  fourth\n];\n",
+		},
+		{
+			"total = first_value + second_value * third_value - 4;\n",
+			"total = first_value +\n  second_value *\n  third_value -\n  4;\n",
+		},
+		{
+			"x = condition_value > 10 ? first_result : second;\n",
+			"x = condition_value > 10\n  ? first_result\n  : second;\n",
+		},
+		{
+			// the outer arguments are broken first, so the inner ones fit
+			"x = f(g(aaaa, bbbb), h(cccc, dddd), eeee);\n",
+			"x = f(\n  g(aaaa, bbbb),\n  h(cccc, dddd),\n  eeee\n);\n",
+		},
+		{
+			// the text after a group up to the next line break must also fit
+			"translate([first_xxx, second_y, third]) { cube(1); }\n",
+			"translate(\n  [first_xxx, second_y, third]\n) {\n  cube(1);\n}\n",
+		},
+		{
+			// comments at the end of the line don't count
+			"x = f(aaaa, bbbb); // a long comment after the call\n",
+			"x = f(aaaa, bbbb); // a long comment after the call\n",
+		},
+		{
+			// comments force the list to be broken
+			"x = f(a, // first\nb);\n",
+			"x = f(\n  a, // first\n  b\n);\n",
+		},
+		{
+			// a let always breaks, so the list containing it breaks first
+			"x = [let (a = 1) a, let (b = 2) b];\n",
+			"x = [\n  let (a = 1)\n    a,\n  let (b = 2)\n    b\n];\n",
+		},
+		{
+			// nested vectors are always broken, but the arguments stay flat
+			"cube([[1, 2], [3, 4]]);\n",
+			"cube([\n  [1, 2],\n  [3, 4]\n]);\n",
+		},
+	}
+	for _, test := range tests {
+		settings := DefaultFormatSettings()
+		settings.maxLineLen = 40
+		output, err := formatSource([]byte(test.input), settings, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != test.expected {
+			t.Errorf("%q: expected:\n%s\ngot:\n%s", test.input, test.expected, output)
+		}
+	}
+}
+
+// Test that wrapping lines is idempotent, with a range of line lengths
+func TestLineWrappingReformat(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(validInputDir, "*.scad"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, maxLineLen := range []int{20, 40, 80} {
+		for _, file := range files {
+			input, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			settings := DefaultFormatSettings()
+			settings.maxLineLen = maxLineLen
+			output, err := formatSource(input, settings, zap.NewNop())
+			if err != nil {
+				t.Fatalf("%s: %s", file, err)
+			}
+			reformatted, err := formatSource(output, settings, zap.NewNop())
+			if err != nil {
+				t.Fatalf("%s: %s", file, err)
+			}
+			if string(reformatted) != string(output) {
+				t.Errorf("%s: reformatting with a maximum line length of %d changed the output", file, maxLineLen)
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/hugheaves/scadformat/internal/config"
+)
+
+// segment is a part of the source code containing one or more top level
+// statements, along with the comments and blank lines before them. Segments
+// always contain whole lines, so statements that share a line are in the same
+// segment.
+type segment struct {
+	firstLine int // first line of the statements in the source
+	lastLine  int // last line of the segment in the source
+	outputEnd int // offset of the end of the segment in the formatted output
+}
+
+// endSegment records the end of the segment containing a top level statement
+// that was just formatted. Comments following the statement on its last line
+// are printed now, rather than before the next statement, so that they are in
+// the same segment. This doesn't change the output, as nothing is printed in
+// between.
+func (v *FormattingVisitor) endSegment(ctx antlr.ParserRuleContext) {
+	last := ctx.GetStop()
+	if last == nil {
+		return
+	}
+	if v.lastPrintedCommentIndex-1 > last.GetTokenIndex() {
+		last = v.tokenStream.Get(v.lastPrintedCommentIndex - 1)
+	}
+	lastLine := tokenEndLine(last)
+	for v.lastPrintedCommentIndex < v.tokenStream.Size() {
+		token := v.tokenStream.Get(v.lastPrintedCommentIndex)
+		if token.GetChannel() == antlr.TokenDefaultChannel || tokenStartLine(token) != lastLine {
+			break
+		}
+		v.printCommentsBefore(token.GetTokenIndex())
+		lastLine = tokenEndLine(token)
+	}
+
+	firstLine := ctx.GetStart().GetLine()
+	n := len(v.segments)
+	if n > 0 && firstLine <= v.segments[n-1].lastLine {
+		v.segments[n-1].lastLine = lastLine
+	} else {
+		v.segments = append(v.segments, segment{firstLine: firstLine, lastLine: lastLine})
+		n++
+	}
+	// the end of the segment in the output is known once it is printed
+	v.doc.markOffset(func(offset int) {
+		v.segments[n-1].outputEnd = offset
+	})
+}
+
+// tokenStartLine returns the line on which the text of a token starts. Some
+// comment tokens include the line ending and indentation before the comment.
+func tokenStartLine(token antlr.Token) int {
+	text := token.GetText()
+	leading := text[:len(text)-len(strings.TrimLeft(text, " \t\r\n"))]
+	return token.GetLine() + strings.Count(leading, "\n")
+}
+
+// tokenEndLine returns the line on which the text of a token ends.
+func tokenEndLine(token antlr.Token) int {
+	return token.GetLine() + strings.Count(strings.TrimRight(token.GetText(), " \t\r\n"), "\n")
+}
+
+// formatLines combines the source code and the formatted output, so that only
+// the segments containing statements that overlap the line ranges are
+// reformatted. The rest of the source is left exactly as it was. If the cursor
+// is tracked, its offset in the combined output is also returned, using
+// outputCursor if the segment containing the cursor is reformatted.
+func formatLines(input []byte, output []byte, segments []segment, lines []config.LineRange, cursor *sourceCursor, outputCursor int) ([]byte, int) {
+	// lineStarts[n] is the offset of the start of line n+1
+	lineStarts := []int{0}
+	for i, b := range input {
+		if b == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	lineStart := func(line int) int {
+		if line > len(lineStarts) {
+			return len(input)
+		}
+		return lineStarts[line-1]
+	}
+
+	// the comments after the last statement are a segment of their own
+	trailing := segment{firstLine: 1, lastLine: len(lineStarts), outputEnd: len(output)}
+	if len(segments) > 0 {
+		trailing.firstLine = segments[len(segments)-1].lastLine + 1
+	}
+	segments = append(segments, trailing)
+
+	var result bytes.Buffer
+	resultCursor := -1
+	sourceStart, outputStart := 0, 0
+	for i, s := range segments {
+		sourceEnd := lineStart(s.lastLine + 1)
+		outputEnd := min(max(s.outputEnd, outputStart), len(output))
+		// the cursor is in the segment if it is before the end of the segment,
+		// or at the very end of the source
+		inSegment := cursor != nil && resultCursor < 0 && (cursor.offset < sourceEnd || i == len(segments)-1)
+		if overlapsLines(lines, s.firstLine, s.lastLine) {
+			if inSegment {
+				resultCursor = result.Len() + min(max(outputCursor-outputStart, 0), outputEnd-outputStart)
+			}
+			result.Write(output[outputStart:outputEnd])
+		} else {
+			if inSegment {
+				resultCursor = result.Len() + max(cursor.offset-sourceStart, 0)
+			}
+			result.Write(input[sourceStart:sourceEnd])
+		}
+		sourceStart, outputStart = sourceEnd, outputEnd
+	}
+	return result.Bytes(), resultCursor
+}
+
+func overlapsLines(lines []config.LineRange, first int, last int) bool {
+	for _, r := range lines {
+		if r.Overlaps(first, last) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/hugheaves/scadformat/internal/config"
+	"go.uber.org/zap"
+)
+
+func formatLinesOf(t *testing.T, input string, lines ...config.LineRange) string {
+	settings := DefaultFormatSettings()
+	settings.lines = lines
+	output, err := formatSource([]byte(input), settings, zap.L())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(output)
+}
+
+func TestFormatLines(t *testing.T) {
+	input := "// header\nx=1; // one\n\n\n/* before a */\nmodule a(){\ncube(1);\n}\ny=2;z=3;\nmodule b(){\ncube(2);\n}\n// end"
+	for _, test := range []struct {
+		lines    []config.LineRange
+		expected string
+	}{
+		{nil, input},
+		{[]config.LineRange{{Start: 2, End: 2}}, "// header\nx = 1; // one\n\n\n/* before a */\nmodule a(){\ncube(1);\n}\ny=2;z=3;\nmodule b(){\ncube(2);\n}\n// end"},
+		{[]config.LineRange{{Start: 7, End: 7}}, "// header\nx=1; // one\n\n\n/* before a */\nmodule a() {\n  cube(1);\n}\ny=2;z=3;\nmodule b(){\ncube(2);\n}\n// end"},
+		{[]config.LineRange{{Start: 9, End: 9}}, "// header\nx=1; // one\n\n\n/* before a */\nmodule a(){\ncube(1);\n}\ny = 2;\nz = 3;\nmodule b(){\ncube(2);\n}\n// end"},
+		{[]config.LineRange{{Start: 3, End: 4}, {Start: 13, End: 13}}, "// header\nx=1; // one\n\n\n/* before a */\nmodule a(){\ncube(1);\n}\ny=2;z=3;\nmodule b(){\ncube(2);\n}\n// end\n"},
+	} {
+		if test.lines == nil {
+			test.lines = []config.LineRange{{Start: 100, End: 200}}
+		}
+		if output := formatLinesOf(t, input, test.lines...); output != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.lines, test.expected, output)
+		}
+	}
+}
+
+// Test that formatting ranges of lines leaves the rest of the source
+// unchanged, and that formatting the result gives the expected output.
+func TestFormatLinesTestData(t *testing.T) {
+	runTestOnDir(t, validInputDir, func(t *testing.T) {
+		input := string(readTestData(t, validInputDir))
+		expected := readTestData(t, expectedDir)
+		if output := formatLinesOf(t, input, config.LineRange{Start: 100000, End: 100000}); output != input {
+			t.Fatalf("expected unchanged input, got %q", output)
+		}
+		lineCount := bytes.Count([]byte(input), []byte("\n")) + 1
+		for start := 1; start <= lineCount; start += 10 {
+			output := formatLinesOf(t, input, config.LineRange{Start: start, End: start + 9})
+			err := validateOutput(t, expected, []byte(formatLinesOf(t, output, config.LineRange{Start: 1, End: lineCount})))
+			if err != nil {
+				t.Fatal(fmt.Errorf("lines %d:%d: %w", start, start+9, err))
+			}
+		}
+	})
+}
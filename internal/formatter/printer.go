@@ -0,0 +1,428 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2023  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"io"
+	"math"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// printMode is the way that the lines of a group are printed.
+type printMode int
+
+const (
+	modeBreak printMode = iota // line breaks end the line
+	modeFlat                   // line breaks are printed as spaces (or nothing)
+)
+
+// printCommand is a document to be printed, with the indentation level and
+// mode of the enclosing group.
+type printCommand struct {
+	indent int
+	mode   printMode
+	doc    doc
+}
+
+// printer lays out a document, and writes it to the output.
+type printer struct {
+	settings        *FormatSettings
+	writer          io.Writer
+	linePos         int             // column that the next character will be written to on the line
+	inLine          bool            // true if the current line contains text
+	line            strings.Builder // text of the current line, which is written when the line ends
+	pendingLineEnds int             // number of line endings not yet written to the output
+	written         int             // number of bytes written to the output
+	cursorText      int             // offset of the cursor within the next text appended to the line, or -1
+	cursorOffset    int             // offset of the cursor in the output, or -1 if it hasn't been reached
+	logger          *zap.Logger
+}
+
+func newPrinter(settings *FormatSettings, writer io.Writer, logger *zap.Logger) *printer {
+	return &printer{
+		settings:     settings,
+		writer:       writer,
+		logger:       logger,
+		cursorText:   -1,
+		cursorOffset: -1,
+	}
+}
+
+// print lays out a document. A group is broken if the text up to the first
+// line break that follows its start doesn't fit within the maximum line
+// length when the group is printed flat. Groups are laid out from the outside
+// in, so a group is only broken after any groups that contain it have been
+// broken. When there is a maximum line length, groups containing a hard line
+// break are always broken. A group that is broken for any other reason doesn't
+// break the groups containing it, which stay flat if the text up to its first
+// line break fits.
+func (p *printer) print(d doc) error {
+	if p.settings.maxLineLen != math.MaxInt {
+		d, _ = propagateBreaks(d)
+	}
+	commands := []printCommand{{indent: 0, mode: modeBreak, doc: d}}
+	remeasure := false
+	for len(commands) > 0 {
+		c := commands[len(commands)-1]
+		commands = commands[:len(commands)-1]
+
+		var err error
+		switch d := c.doc.(type) {
+		case docConcat:
+			for i := len(d) - 1; i >= 0; i-- {
+				commands = append(commands, printCommand{indent: c.indent, mode: c.mode, doc: d[i]})
+			}
+		case docText:
+			p.logger.Debug("printString |" + string(d) + "|")
+			err = p.appendToLine(string(d), c.indent, true)
+		case docRawText:
+			err = p.appendToLine(string(d), c.indent, false)
+		case docSpace:
+			err = p.printSpace(c.indent)
+		case docLine:
+			if c.mode == modeBreak {
+				err = p.endLine()
+			} else if !d.soft {
+				err = p.printSpace(c.indent)
+			}
+		case docHardLine:
+			remeasure = remeasure || (c.mode == modeFlat && p.inLine)
+			err = p.endLine()
+		case docNewLine:
+			remeasure = remeasure || c.mode == modeFlat
+			err = p.printNewLine()
+		case docLiteralLine:
+			remeasure = remeasure || c.mode == modeFlat
+			err = p.writeLine("\n", false)
+		case docGroup:
+			// a group in a flat group is also flat, as it was measured with
+			// the flat group, unless a line break has been printed since then
+			mode := modeFlat
+			if c.mode == modeBreak || remeasure {
+				remeasure = false
+				if !p.fits(printCommand{indent: c.indent, mode: modeFlat, doc: d.contents}, commands) {
+					mode = modeBreak
+				}
+			}
+			if d.shouldBreak {
+				mode = modeBreak
+			}
+			commands = append(commands, printCommand{indent: c.indent, mode: mode, doc: d.contents})
+		case docIndent:
+			commands = append(commands, printCommand{indent: c.indent + 1, mode: c.mode, doc: d.contents})
+		case docTrailing:
+			commands = append(commands, printCommand{indent: c.indent, mode: c.mode, doc: d.contents})
+		case docIfBreak:
+			branch := d.flat
+			if c.mode == modeBreak {
+				branch = d.broken
+			}
+			commands = append(commands, printCommand{indent: c.indent, mode: c.mode, doc: branch})
+		case docCursor:
+			p.cursorText = int(d)
+		case docOffset:
+			d(p.nextLineOffset())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// propagateBreaks returns a copy of a document in which every group that
+// contains a hard line break is broken, and reports whether the document
+// contains one. Only the line breaks that would be printed if the enclosing
+// groups were flat count, such as those after a line comment or the arguments
+// of a let. The groups containing them can't be printed on a single line, so
+// breaking them first keeps groups breaking from the outside in.
+func propagateBreaks(d doc) (doc, bool) {
+	switch d := d.(type) {
+	case docConcat:
+		result := make(docConcat, len(d))
+		broken := false
+		for i, child := range d {
+			var childBroken bool
+			result[i], childBroken = propagateBreaks(child)
+			broken = broken || childBroken
+		}
+		return result, broken
+	case docGroup:
+		contents, broken := propagateBreaks(d.contents)
+		return docGroup{contents: contents, shouldBreak: d.shouldBreak || broken}, broken
+	case docIndent:
+		contents, broken := propagateBreaks(d.contents)
+		return docIndent{contents: contents}, broken
+	case docTrailing:
+		contents, broken := propagateBreaks(d.contents)
+		return docTrailing{contents: contents}, broken
+	case docIfBreak:
+		// the broken branch is only printed once the group is broken
+		broken, _ := propagateBreaks(d.broken)
+		flat, flatBroken := propagateBreaks(d.flat)
+		return docIfBreak{broken: broken, flat: flat}, flatBroken
+	case docHardLine, docNewLine:
+		return d, true
+	default:
+		return d, false
+	}
+}
+
+// fits returns true if the text from the start of next up to the first line
+// break fits on the current line. next is measured flat, and the commands
+// that follow it (rest, with the next command last) are measured in the mode
+// of their enclosing groups.
+func (p *printer) fits(next printCommand, rest []printCommand) bool {
+	if p.settings.maxLineLen == math.MaxInt {
+		return true
+	}
+	column := p.indentWidth(next.indent)
+	if p.inLine {
+		column = p.linePos
+	}
+	inLine := p.inLine
+	commands := []printCommand{next}
+	for column <= p.settings.maxLineLen {
+		if len(commands) == 0 {
+			if len(rest) == 0 {
+				return true
+			}
+			commands = append(commands, rest[len(rest)-1])
+			rest = rest[:len(rest)-1]
+			continue
+		}
+		c := commands[len(commands)-1]
+		commands = commands[:len(commands)-1]
+
+		switch d := c.doc.(type) {
+		case docConcat:
+			for i := len(d) - 1; i >= 0; i-- {
+				commands = append(commands, printCommand{indent: c.indent, mode: c.mode, doc: d[i]})
+			}
+		case docText:
+			column = p.columnAfter(column, string(d))
+			inLine = inLine || d != ""
+		case docRawText:
+			column = p.columnAfter(column, string(d))
+			inLine = inLine || d != ""
+		case docSpace:
+			if inLine {
+				column++
+			}
+		case docLine:
+			if c.mode == modeBreak {
+				if inLine {
+					return true
+				}
+			} else if !d.soft && inLine {
+				column++
+			}
+		case docHardLine:
+			if inLine {
+				return true
+			}
+		case docNewLine, docLiteralLine:
+			return true
+		case docGroup:
+			mode := c.mode
+			if d.shouldBreak {
+				mode = modeBreak
+			}
+			commands = append(commands, printCommand{indent: c.indent, mode: mode, doc: d.contents})
+		case docIndent:
+			commands = append(commands, printCommand{indent: c.indent, mode: c.mode, doc: d.contents})
+		case docIfBreak:
+			branch := d.flat
+			if c.mode == modeBreak {
+				branch = d.broken
+			}
+			commands = append(commands, printCommand{indent: c.indent, mode: c.mode, doc: branch})
+		}
+	}
+	return false
+}
+
+// printSpace adds a space to the line, if the current line contains is not empty. Otherwise, this function does nothing.
+func (p *printer) printSpace(indent int) error {
+	if p.inLine {
+		return p.appendToLine(" ", indent, true)
+	}
+	return nil
+}
+
+// endLine calls printNewLine if the current line is not empty. Otherwise, it does nothing.
+func (p *printer) endLine() error {
+	p.logger.Debug("endLine")
+	if p.inLine {
+		return p.printNewLine()
+	}
+	return nil
+}
+
+// printNewLine ends the current line.
+func (p *printer) printNewLine() error {
+	p.logger.Debug("printNewLine")
+	return p.writeLine(p.settings.endOfLine, true)
+}
+
+// writeLine writes the current line to the output, followed by lineEnd. If
+// trim is true, trailing carriage returns are removed from the line, as is
+// trailing whitespace if enabled in the settings.
+//
+// Line endings are not written until more text follows them, so that the
+// ending of the last line can be controlled by finish.
+func (p *printer) writeLine(lineEnd string, trim bool) error {
+	text := p.line.String()
+	if trim {
+		text = strings.TrimRight(text, "\r")
+		if p.settings.trimTrailingWhitespace {
+			text = strings.TrimRight(text, " \t\r")
+		}
+	}
+	p.line.Reset()
+	p.inLine = false
+	p.linePos = 0
+
+	if text != "" || !trim {
+		err := p.writePendingLineEnds()
+		if err != nil {
+			return err
+		}
+		err = p.write(text)
+		if err != nil {
+			return err
+		}
+	}
+	if trim {
+		p.pendingLineEnds++
+		return nil
+	}
+	return p.write(lineEnd)
+}
+
+func (p *printer) writePendingLineEnds() error {
+	for ; p.pendingLineEnds > 0; p.pendingLineEnds-- {
+		err := p.write(p.settings.endOfLine)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *printer) write(text string) error {
+	n, err := io.WriteString(p.writer, text)
+	p.written += n
+	return err
+}
+
+// nextLineOffset returns the offset in the output at which the line after the
+// last ended line starts. Only the first pending line ending is counted, as any
+// others are blank lines that belong to the following text.
+func (p *printer) nextLineOffset() int {
+	if p.pendingLineEnds > 0 {
+		return p.written + len(p.settings.endOfLine)
+	}
+	return p.written
+}
+
+// lineOffset returns the offset in the output at which the next text appended
+// to the current line will be written.
+func (p *printer) lineOffset() int {
+	return p.written + p.pendingLineEnds*len(p.settings.endOfLine) + p.line.Len()
+}
+
+// finish writes the remainder of the output. If the settings require a final
+// newline, the last line is ended if necessary. Otherwise, any line endings at
+// the end of the output are removed.
+func (p *printer) finish() error {
+	if !p.settings.insertFinalNewline {
+		p.pendingLineEnds = 0
+		text := p.line.String()
+		if text == "" {
+			return nil
+		}
+		err := p.writeLine("", true)
+		p.pendingLineEnds = 0
+		return err
+	}
+	if p.inLine {
+		err := p.printNewLine()
+		if err != nil {
+			return err
+		}
+	}
+	return p.writePendingLineEnds()
+}
+
+// appendToLine appends a string to the current line. If the line is empty
+// and doIndent is true, the line is first indented by indent levels.
+func (p *printer) appendToLine(strVal string, indent int, doIndent bool) error {
+	if len(strVal) == 0 {
+		return nil
+	}
+	if !p.inLine {
+		if doIndent {
+			p.outputIndent(indent)
+		}
+		p.inLine = true
+	}
+	if p.cursorText >= 0 {
+		p.cursorOffset = p.lineOffset() + p.cursorText
+		p.cursorText = -1
+	}
+	p.line.WriteString(strVal)
+	p.linePos = p.columnAfter(p.linePos, strVal)
+	return nil
+}
+
+// outputIndent indents the current line by indent levels. When indenting with
+// tabs, a tab is written for each level, so continuation lines are indented
+// with tabs too.
+func (p *printer) outputIndent(indent int) {
+	if p.settings.useTabs {
+		p.line.WriteString(strings.Repeat("\t", indent))
+	} else {
+		p.line.WriteString(strings.Repeat(" ", indent*p.settings.indentSize))
+	}
+	p.linePos += p.indentWidth(indent)
+}
+
+// indentWidth returns the number of columns taken by indent levels.
+func (p *printer) indentWidth(indent int) int {
+	if p.settings.useTabs {
+		return indent * p.settings.tabColumns()
+	}
+	return indent * p.settings.indentSize
+}
+
+// columnAfter returns the column after text that starts at column. Tabs
+// advance to the next tab stop.
+func (p *printer) columnAfter(column int, text string) int {
+	for _, r := range text {
+		if r == '\t' {
+			column += p.settings.tabColumns() - column%p.settings.tabColumns()
+		} else {
+			column++
+		}
+	}
+	return column
+}
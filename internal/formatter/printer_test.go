@@ -0,0 +1,122 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestPrinter(t *testing.T) {
+	// f(aaaa, [bb, cc]) with the arguments and the vector as groups
+	d := docConcat{
+		docText("f"),
+		testList("()", false, docText("aaaa"), testList("[]", false, docText("bb"), docText("cc"))),
+		docText(";"),
+		docTrailing{contents: docConcat{docSpace{}, docText("// comment")}},
+		docHardLine{},
+	}
+
+	tests := []struct {
+		maxLineLen int
+		expected   string
+	}{
+		{80, "f(aaaa, [bb, cc]); // comment\n"},
+		{18, "f(aaaa, [bb, cc]); // comment\n"},
+		{17, "f(\n  aaaa,\n  [bb, cc]\n); // comment\n"},
+		{8, "f(\n  aaaa,\n  [\n    bb,\n    cc\n  ]\n); // comment\n"},
+	}
+	for _, test := range tests {
+		if output := testPrint(t, d, test.maxLineLen); output != test.expected {
+			t.Errorf("max line length %d: expected %q, got %q", test.maxLineLen, test.expected, output)
+		}
+	}
+}
+
+func TestPrinterForcedBreak(t *testing.T) {
+	// f(aaaa, [bb, cc]) with a vector that is always broken
+	forcedGroup := docConcat{
+		docText("f"),
+		testList("()", false, docText("aaaa"), testList("[]", true, docText("bb"), docText("cc"))),
+		docText(";"),
+	}
+	// [let (a = 1) a, b] with a hard line after the arguments of the let
+	hardLine := docConcat{
+		docText("x = "),
+		testList("[]", false, docConcat{docText("let (a = 1)"), docHardLine{}, docIndent{contents: docText("a")}}, docText("b")),
+		docText(";"),
+	}
+
+	tests := []struct {
+		d          doc
+		maxLineLen int
+		expected   string
+	}{
+		// the arguments stay flat, as they fit up to the break in the vector
+		{forcedGroup, 80, "f(aaaa, [\n  bb,\n  cc\n]);"},
+		{forcedGroup, 8, "f(\n  aaaa,\n  [\n    bb,\n    cc\n  ]\n);"},
+		{hardLine, 80, "x = [\n  let (a = 1)\n    a,\n  b\n];"},
+		// groups are only broken when there is a maximum line length
+		{forcedGroup, math.MaxInt, "f(aaaa, [\n  bb,\n  cc\n]);"},
+		{hardLine, math.MaxInt, "x = [let (a = 1)\n  a, b];"},
+	}
+	for _, test := range tests {
+		if output := testPrint(t, test.d, test.maxLineLen); output != test.expected+"\n" {
+			t.Errorf("max line length %d: expected %q, got %q", test.maxLineLen, test.expected, output)
+		}
+	}
+}
+
+// testList returns a group containing a comma separated list of items between
+// the two characters of open, laid out like the lists of the visitor.
+func testList(open string, shouldBreak bool, items ...doc) doc {
+	var contents docConcat
+	for i, item := range items {
+		if i > 0 {
+			contents = append(contents, docText(","), docLine{})
+		}
+		contents = append(contents, item)
+	}
+	return docGroup{shouldBreak: shouldBreak, contents: docConcat{
+		docText(open[:1]),
+		docIfBreak{broken: docIndent{contents: docConcat{docLine{soft: true}, contents}}, flat: contents},
+		docLine{soft: true},
+		docText(open[1:]),
+	}}
+}
+
+// testPrint prints a document with the default settings and a maximum line
+// length.
+func testPrint(t *testing.T, d doc, maxLineLen int) string {
+	settings := DefaultFormatSettings()
+	settings.maxLineLen = maxLineLen
+	var buf bytes.Buffer
+	p := newPrinter(settings, &buf, zap.NewNop())
+	err := p.print(d)
+	if err == nil {
+		err = p.finish()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,88 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// jsonReport is the report written to stdout in JSON mode.
+type jsonReport struct {
+	Files   []jsonFileRecord `json:"files"`
+	Summary map[string]int   `json:"summary"`
+}
+
+// jsonFileRecord is the result for a single file in a JSON report.
+type jsonFileRecord struct {
+	Path         string            `json:"path"`
+	Status       string            `json:"status"`
+	Error        string            `json:"error,omitempty"`
+	SyntaxErrors []jsonSyntaxError `json:"syntax_errors,omitempty"`
+	ElapsedMs    float64           `json:"elapsed_ms"`
+	LinesAdded   int               `json:"lines_added"`
+	LinesRemoved int               `json:"lines_removed"`
+	Diff         string            `json:"diff,omitempty"`
+}
+
+type jsonSyntaxError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// writeJSONReport writes a JSON report of the results to stdout, if JSON mode
+// is enabled. In diff mode, each record includes the diff for the file.
+func (f *Formatter) writeJSONReport(results []*FileResult, summary *Summary) error {
+	if !f.config.JSON {
+		return nil
+	}
+	report := jsonReport{Files: []jsonFileRecord{}, Summary: map[string]int{"total": summary.Total()}}
+	for status := StatusUnchanged; status <= StatusIgnored; status++ {
+		report.Summary[status.String()] = summary.Count(status)
+	}
+	for _, result := range results {
+		record := jsonFileRecord{
+			Path:         result.Path,
+			Status:       result.Status.String(),
+			ElapsedMs:    float64(result.Elapsed.Microseconds()) / 1000,
+			LinesAdded:   result.LinesAdded,
+			LinesRemoved: result.LinesRemoved,
+		}
+		if result.Err != nil {
+			record.Error = result.Err.Error()
+		}
+		for _, syntaxErr := range result.SyntaxErrors {
+			record.SyntaxErrors = append(record.SyntaxErrors, jsonSyntaxError{Line: syntaxErr.Line, Column: syntaxErr.Column, Message: syntaxErr.Msg})
+		}
+		if f.config.Diff {
+			record.Diff = string(result.report)
+		}
+		report.Files = append(report.Files, record)
+	}
+
+	encoder := json.NewEncoder(f.stdout)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(report)
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hugheaves/scadformat/internal/config"
+)
+
+func TestJSONReport(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".git/HEAD":         "",
+		".scadformatignore": "vendor.scad\n",
+		"formatted.scad":    "x = 1;\n",
+		"unformatted.scad":  "module a(){cube(1);}\n",
+		"invalid.scad":      "x = 1;\ny = ;\n",
+		"vendor.scad":       "x=1;\n",
+	})
+
+	var stdout strings.Builder
+	f := NewFormatter(&config.MainConfig{Diff: true, JSON: true, TargetPaths: []string{root}})
+	f.stdout = &stdout
+	_, err := f.Format()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var report jsonReport
+	err = json.Unmarshal([]byte(stdout.String()), &report)
+	if err != nil {
+		t.Fatalf("invalid report: %s\n%s", err, stdout.String())
+	}
+	records := make(map[string]jsonFileRecord)
+	for _, record := range report.Files {
+		records[filepath.Base(record.Path)] = record
+	}
+
+	for name, status := range map[string]string{
+		"formatted.scad":   "unchanged",
+		"unformatted.scad": "would-reformat",
+		"invalid.scad":     "syntax-error",
+		"vendor.scad":      "ignored",
+	} {
+		if records[name].Status != status {
+			t.Errorf("%s: expected status %s, got %q", name, status, records[name].Status)
+		}
+	}
+
+	unformatted := records["unformatted.scad"]
+	if unformatted.LinesAdded != 3 || unformatted.LinesRemoved != 1 || !strings.Contains(unformatted.Diff, "+  cube(1);\n") {
+		t.Errorf("unexpected change for unformatted.scad: %+v", unformatted)
+	}
+	syntaxErrs := records["invalid.scad"].SyntaxErrors
+	if len(syntaxErrs) != 1 || syntaxErrs[0].Line != 2 || syntaxErrs[0].Column != 4 {
+		t.Errorf("expected a syntax error at 2:4, got %+v", syntaxErrs)
+	}
+	if report.Summary["total"] != 4 || report.Summary["ignored"] != 1 || report.Summary["reformatted"] != 0 {
+		t.Errorf("unexpected summary: %v", report.Summary)
+	}
+}
@@ -0,0 +1,150 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type FileStatus int
+
+const (
+	StatusUnchanged     FileStatus = iota // file was already formatted
+	StatusReformatted                     // file was rewritten with formatted code
+	StatusWouldReformat                   // file is not formatted (check mode)
+	StatusSyntaxError                     // file could not be parsed
+	StatusIOError                         // file could not be read or written
+	StatusIgnored                         // file matched the ignore rules
+)
+
+func (s FileStatus) String() string {
+	switch s {
+	case StatusUnchanged:
+		return "unchanged"
+	case StatusReformatted:
+		return "reformatted"
+	case StatusWouldReformat:
+		return "would-reformat"
+	case StatusSyntaxError:
+		return "syntax-error"
+	case StatusIOError:
+		return "io-error"
+	case StatusIgnored:
+		return "ignored"
+	default:
+		return fmt.Sprintf("FileStatus(%d)", int(s))
+	}
+}
+
+// IsError returns true if the file could not be formatted.
+func (s FileStatus) IsError() bool {
+	return s == StatusSyntaxError || s == StatusIOError
+}
+
+// FileResult is the outcome of formatting a single file.
+type FileResult struct {
+	Path         string
+	Status       FileStatus
+	Err          error
+	SyntaxErrors []*SyntaxError // all of the syntax errors, if the file could not be parsed
+	Elapsed      time.Duration  // time taken to format the file
+	LinesAdded   int            // number of lines added by formatting
+	LinesRemoved int            // number of lines removed by formatting
+	output       []byte         // the formatted code, if formatting succeeded
+	report       []byte         // output for stdout in check or diff mode
+}
+
+// setError records a failure to format the file, classifying the error as
+// either a syntax error or an I/O error.
+func (r *FileResult) setError(err error) {
+	r.Err = err
+	var syntaxErrs *SyntaxErrors
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErrs) {
+		r.Status = StatusSyntaxError
+		r.SyntaxErrors = syntaxErrs.Errors
+	} else if errors.As(err, &syntaxErr) {
+		r.Status = StatusSyntaxError
+		r.SyntaxErrors = []*SyntaxError{syntaxErr}
+	} else {
+		r.Status = StatusIOError
+	}
+}
+
+// setChanges sets the status of a formatted file, and counts the lines changed
+// by formatting.
+func (r *FileResult) setChanges(status FileStatus, input []byte, output []byte) {
+	r.Status = status
+	if status != StatusUnchanged {
+		r.LinesAdded, r.LinesRemoved = countChangedLines(input, output)
+	}
+}
+
+func (r *FileResult) log() {
+	switch {
+	case r.Status.IsError():
+		zap.S().Errorf("%s: %s", r.Path, r.Err)
+	case r.Status == StatusIgnored:
+		// ignored files are logged when they are found
+	default:
+		zap.S().Infof("%s: %s", r.Path, r.Status)
+	}
+}
+
+// Summary counts the results of a formatting run.
+type Summary struct {
+	counts map[FileStatus]int
+}
+
+func NewSummary() *Summary {
+	return &Summary{counts: make(map[FileStatus]int)}
+}
+
+func (s *Summary) Add(result *FileResult) {
+	s.counts[result.Status]++
+}
+
+// Count returns the number of files with the given status.
+func (s *Summary) Count(status FileStatus) int {
+	return s.counts[status]
+}
+
+// Total returns the number of files processed.
+func (s *Summary) Total() int {
+	total := 0
+	for _, count := range s.counts {
+		total += count
+	}
+	return total
+}
+
+func (s *Summary) String() string {
+	var parts []string
+	for _, status := range []FileStatus{StatusReformatted, StatusWouldReformat, StatusUnchanged, StatusIgnored, StatusSyntaxError, StatusIOError} {
+		if s.Count(status) > 0 || status == StatusUnchanged {
+			parts = append(parts, fmt.Sprintf("%d %s", s.Count(status), status))
+		}
+	}
+	return fmt.Sprintf("%d files processed: %s", s.Total(), strings.Join(parts, ", "))
+}
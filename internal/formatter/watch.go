@@ -0,0 +1,263 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// watchDebounce is how long a file must be left alone after a change before it
+// is reformatted. Editors often save a file with several writes (or a write
+// followed by a rename), and we only want to format the final result.
+const watchDebounce = 250 * time.Millisecond
+
+type fileWatcher struct {
+	formatter   *Formatter
+	watcher     *fsnotify.Watcher
+	sourceDirs  map[string]bool           // directories in which every .scad file is formatted
+	sourceFiles map[string]bool           // individual files specified as targets
+	pending     map[string]*debounceTimer // debounce timers for recently changed files
+	written     map[string][]byte         // content last written to each file by the formatter
+	ready       chan *debounceTimer       // receives debounce timers when they expire
+	done        <-chan struct{}           // closed when the watch is stopped
+}
+
+// debounceTimer delays formatting a changed file until it stops changing.
+type debounceTimer struct {
+	fileName string
+	timer    *time.Timer
+}
+
+// Watch watches the configured target paths, and reformats .scad files as they
+// are changed or created. Watch runs until the context is cancelled. Errors
+// formatting individual files are logged, and do not stop the watch.
+func (f *Formatter) Watch(ctx context.Context) error {
+	if len(f.config.TargetPaths) == 0 {
+		return errors.New("watch mode requires at least one file or directory")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	w := &fileWatcher{
+		formatter:   f,
+		watcher:     watcher,
+		sourceDirs:  make(map[string]bool),
+		sourceFiles: make(map[string]bool),
+		pending:     make(map[string]*debounceTimer),
+		written:     make(map[string][]byte),
+		ready:       make(chan *debounceTimer),
+		done:        ctx.Done(),
+	}
+
+	for _, targetPath := range f.config.TargetPaths {
+		err = w.addTarget(filepath.Clean(targetPath))
+		if err != nil {
+			return err
+		}
+	}
+
+	zap.S().Infof("watching %d directories for changes", len(watcher.WatchList()))
+	return w.run(ctx)
+}
+
+func (w *fileWatcher) addTarget(targetPath string) error {
+	info, err := os.Stat(targetPath)
+	if err != nil {
+		return err
+	}
+	rule, err := w.formatter.ignore.Match(targetPath, info.IsDir())
+	if err != nil {
+		return err
+	}
+	if rule != nil {
+		zap.S().Warnf("skipping %s: ignored by %s", targetPath, rule)
+		return nil
+	}
+	if !info.IsDir() {
+		// Watch the parent directory rather than the file itself, so that we
+		// continue to receive events when an editor saves by replacing the file.
+		w.sourceFiles[targetPath] = true
+		return w.watcher.Add(filepath.Dir(targetPath))
+	}
+	return w.addDir(targetPath, false)
+}
+
+// addDir starts watching a directory, and (if recursion is enabled) all of
+// its subdirectories. If formatExisting is true, the .scad files already in the
+// directory are scheduled for formatting. This is used for directories created
+// after the watch started, as their files may have been written before the
+// directory was being watched.
+func (w *fileWatcher) addDir(dir string, formatExisting bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && w.isIgnored(path, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if path != dir && !w.formatter.config.Recurse {
+				return filepath.SkipDir
+			}
+			zap.S().Debugf("watching directory %s", path)
+			w.sourceDirs[path] = true
+			return w.watcher.Add(path)
+		}
+		if formatExisting && isSourceFile(path) {
+			w.schedule(path)
+		}
+		return nil
+	})
+}
+
+func (w *fileWatcher) run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			for _, pending := range w.pending {
+				pending.timer.Stop()
+			}
+			zap.S().Info("stopped watching for changes")
+			return nil
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			zap.S().Errorf("watch error: %s", err)
+		case expired := <-w.ready:
+			if w.pending[expired.fileName] != expired {
+				// the timer was replaced after it expired, so the file is formatted
+				// when the replacement expires
+				continue
+			}
+			delete(w.pending, expired.fileName)
+			w.formatChangedFile(expired.fileName)
+		}
+	}
+}
+
+func (w *fileWatcher) handleEvent(event fsnotify.Event) {
+	zap.S().Debugf("watch event: %s", event)
+	path := filepath.Clean(event.Name)
+
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		delete(w.written, path)
+		delete(w.sourceDirs, path)
+		return
+	}
+
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	if event.Has(fsnotify.Create) && w.formatter.config.Recurse && w.sourceDirs[filepath.Dir(path)] {
+		info, err := os.Stat(path)
+		if err == nil && info.IsDir() {
+			if w.isIgnored(path, true) {
+				return
+			}
+			err = w.addDir(path, true)
+			if err != nil {
+				zap.S().Errorf("failed to watch directory %s: %s", path, err)
+			}
+			return
+		}
+	}
+
+	if w.isWatchedFile(path) {
+		w.schedule(path)
+	}
+}
+
+func (w *fileWatcher) isWatchedFile(path string) bool {
+	return w.sourceFiles[path] || (w.sourceDirs[filepath.Dir(path)] && isSourceFile(path) && !w.isIgnored(path, false))
+}
+
+// isIgnored returns true if a path found in a watched directory matches the
+// ignore rules. If the ignore files can't be read, the error is logged and the
+// path is ignored.
+func (w *fileWatcher) isIgnored(path string, isDir bool) bool {
+	rule, err := w.formatter.ignore.Match(path, isDir)
+	if err != nil {
+		zap.S().Errorf("%s: %s", path, err)
+		return true
+	}
+	if rule != nil {
+		zap.S().Debugf("skipping %s: ignored by %s", path, rule)
+		return true
+	}
+	return false
+}
+
+// schedule (re)starts the debounce timer for a file. If the timer has already
+// expired, but not yet been received by run, it is replaced by a new timer.
+func (w *fileWatcher) schedule(fileName string) {
+	if pending, ok := w.pending[fileName]; ok && pending.timer.Stop() {
+		pending.timer.Reset(watchDebounce)
+		return
+	}
+	pending := &debounceTimer{fileName: fileName}
+	pending.timer = time.AfterFunc(watchDebounce, func() {
+		select {
+		case w.ready <- pending:
+		case <-w.done:
+		}
+	})
+	w.pending[fileName] = pending
+}
+
+func (w *fileWatcher) formatChangedFile(fileName string) {
+	input, err := os.ReadFile(fileName)
+	if err != nil {
+		// the file may have been removed or renamed since the change event
+		zap.S().Debugf("skipping %s: %s", fileName, err)
+		return
+	}
+	if written, ok := w.written[fileName]; ok && bytes.Equal(input, written) {
+		zap.S().Debugf("skipping %s: content was written by the formatter", fileName)
+		return
+	}
+
+	result := w.formatter.formatFile(fileName)
+	result.log()
+	if !result.Status.IsError() {
+		w.written[fileName] = result.output
+	}
+}
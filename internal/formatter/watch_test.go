@@ -0,0 +1,52 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package formatter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleAfterTimerExpired(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	w := &fileWatcher{
+		pending: make(map[string]*debounceTimer),
+		ready:   make(chan *debounceTimer),
+		done:    done,
+	}
+
+	w.schedule("part.scad")
+	first := w.pending["part.scad"]
+	// let the timer expire, so that it is blocked sending on the ready channel
+	time.Sleep(2 * watchDebounce)
+	w.schedule("part.scad")
+
+	expired := <-w.ready
+	if expired != first {
+		t.Fatal("expected the first timer to expire first")
+	}
+	if w.pending["part.scad"] == first {
+		t.Error("expected the expired timer to be replaced")
+	}
+	expired = <-w.ready
+	if expired != w.pending["part.scad"] {
+		t.Error("expected the replacement timer to expire")
+	}
+}
@@ -0,0 +1,223 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+// Package gitdiff runs git to find the lines of files that have changed, and to
+// read and update the content of files that are staged for the next commit.
+package gitdiff
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hugheaves/scadformat/internal/config"
+)
+
+var hunkHeaderRegex = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// hunk is a change to a file. Lines are numbered from 1. If a hunk deletes
+// lines without adding any, newStart is the line before the deleted lines (0
+// at the start of the file), and similarly for oldStart if it only adds lines.
+type hunk struct {
+	oldStart int
+	oldLines int
+	newStart int
+	newLines int
+}
+
+// ChangedLines returns the lines that have changed in the working tree files of
+// the repository containing dir, by file (using absolute paths). If staged is
+// false, the changes are those since the revision rev. Otherwise, the changes
+// are those that are staged for the next commit, and rev is ignored. Line
+// numbers are always those of the working tree files, even if they contain
+// changes that are not staged.
+//
+// Deleted files and untracked files are not included. If lines are deleted
+// without being replaced, the lines before and after them are considered to
+// have changed.
+func ChangedLines(dir string, rev string, staged bool) (map[string][]config.LineRange, error) {
+	root, err := Toplevel(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes map[string][]hunk
+	if staged {
+		changes, err = diff(dir, "--cached")
+	} else {
+		// the revision is never an option, even if it starts with "-"
+		changes, err = diff(dir, "--end-of-options", rev)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var unstaged map[string][]hunk
+	if staged {
+		// staged changes are numbered by their lines in the index, which
+		// differ from the working tree if there are unstaged changes
+		unstaged, err = diff(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	lines := make(map[string][]config.LineRange)
+	for path, hunks := range changes {
+		var ranges []config.LineRange
+		for _, h := range hunks {
+			r := h.newRange()
+			if staged {
+				r = mapRange(r, unstaged[path])
+			}
+			ranges = append(ranges, r)
+		}
+		lines[filepath.Join(root, filepath.FromSlash(path))] = ranges
+	}
+	return lines, nil
+}
+
+// newRange returns the lines of the new file that were changed by the hunk.
+func (h hunk) newRange() config.LineRange {
+	if h.newLines == 0 {
+		return config.LineRange{Start: max(h.newStart, 1), End: h.newStart + 1}
+	}
+	return config.LineRange{Start: h.newStart, End: h.newStart + h.newLines - 1}
+}
+
+// mapRange maps a range of lines in the old version of a file to the new
+// version, given the hunks that change the old version into the new version.
+func mapRange(r config.LineRange, hunks []hunk) config.LineRange {
+	return config.LineRange{Start: mapLine(r.Start, hunks, false), End: mapLine(r.End, hunks, true)}
+}
+
+// mapLine maps a line in the old version of a file to the new version. Lines
+// that were changed are mapped to the start (or end) of the lines that replaced
+// them.
+func mapLine(line int, hunks []hunk, end bool) int {
+	offset := 0
+	for _, h := range hunks {
+		oldEnd := h.oldStart + h.oldLines - 1
+		if h.oldLines == 0 {
+			// lines were inserted after oldStart
+			oldEnd = h.oldStart
+		}
+		switch {
+		case h.oldLines > 0 && line >= h.oldStart && line <= oldEnd:
+			if end {
+				return max(h.newStart+h.newLines-1, h.newStart, 1)
+			}
+			return max(h.newStart, 1)
+		case line > oldEnd:
+			offset = h.newStart + h.newLines - 1 - oldEnd
+			if h.newLines == 0 {
+				offset = h.newStart - oldEnd
+			}
+		}
+	}
+	return line + offset
+}
+
+// diff runs "git diff" with the given arguments, and returns the hunks for
+// each file, by path relative to the root of the repository.
+func diff(dir string, args ...string) (map[string][]hunk, error) {
+	args = append([]string{"diff", "--unified=0", "--no-color", "--no-ext-diff",
+		"--diff-filter=d", "--src-prefix=a/", "--dst-prefix=b/"}, args...)
+	output, err := git(dir, append(args, "--")...)
+	if err != nil {
+		return nil, err
+	}
+	return parseDiff(bytes.NewReader(output))
+}
+
+// parseDiff parses the output of "git diff --unified=0".
+func parseDiff(r io.Reader) (map[string][]hunk, error) {
+	changes := make(map[string][]hunk)
+	path := ""
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "diff "):
+			path = ""
+		case strings.HasPrefix(line, "+++ "):
+			name := strings.TrimPrefix(line, "+++ ")
+			if strings.HasPrefix(name, `"`) {
+				unquoted, err := strconv.Unquote(name)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path in diff: %s", name)
+				}
+				name = unquoted
+			}
+			path = strings.TrimPrefix(name, "b/")
+		case strings.HasPrefix(line, "@@ ") && path != "":
+			matches := hunkHeaderRegex.FindStringSubmatch(line)
+			if matches == nil {
+				return nil, fmt.Errorf("invalid hunk header in diff: %s", line)
+			}
+			changes[path] = append(changes[path], hunk{
+				oldStart: atoi(matches[1], 0),
+				oldLines: atoi(matches[2], 1),
+				newStart: atoi(matches[3], 0),
+				newLines: atoi(matches[4], 1),
+			})
+		}
+	}
+	return changes, scanner.Err()
+}
+
+// atoi parses a number from a hunk header, which may be omitted.
+func atoi(s string, defaultValue int) int {
+	if s == "" {
+		return defaultValue
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// git runs a git command in dir, and returns its output.
+func git(dir string, args ...string) ([]byte, error) {
+	return gitWithInput(dir, nil, args...)
+}
+
+// gitWithInput runs a git command in dir with the given input, and returns its
+// output.
+func gitWithInput(dir string, input []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], message)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return output, nil
+}
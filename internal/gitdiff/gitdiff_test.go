@@ -0,0 +1,151 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package gitdiff
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hugheaves/scadformat/internal/config"
+)
+
+func TestParseDiff(t *testing.T) {
+	diff := `diff --git a/part.scad b/part.scad
+index 1111111..2222222 100644
+--- a/part.scad
++++ b/part.scad
+@@ -2 +2,2 @@ module a() {
+-x=1;
++x=2;
++y=3;
+@@ -10,3 +11,0 @@
+-a;
+-b;
+-c;
+diff --git a/lib/new file.scad b/lib/new file.scad
+new file mode 100644
+--- /dev/null
++++ "b/lib/new file.scad"
+@@ -0,0 +1,2 @@
++a;
++b;
+`
+	changes, err := parseDiff(strings.NewReader(diff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]hunk{
+		"part.scad":         {{oldStart: 2, oldLines: 1, newStart: 2, newLines: 2}, {oldStart: 10, oldLines: 3, newStart: 11, newLines: 0}},
+		"lib/new file.scad": {{oldStart: 0, oldLines: 0, newStart: 1, newLines: 2}},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+}
+
+func TestMapLine(t *testing.T) {
+	// line 3 replaced by 2 lines, 2 lines inserted after line 5, lines 8-9 deleted
+	hunks := []hunk{
+		{oldStart: 3, oldLines: 1, newStart: 3, newLines: 2},
+		{oldStart: 5, oldLines: 0, newStart: 7, newLines: 2},
+		{oldStart: 8, oldLines: 2, newStart: 10, newLines: 0},
+	}
+	for _, test := range []struct{ line, start, end int }{
+		{1, 1, 1},
+		{3, 3, 4},
+		{4, 5, 5},
+		{5, 6, 6},
+		{6, 9, 9},
+		{7, 10, 10},
+		{8, 10, 10},
+		{10, 11, 11},
+	} {
+		if start := mapLine(test.line, hunks, false); start != test.start {
+			t.Errorf("line %d: expected start %d, got %d", test.line, test.start, start)
+		}
+		if end := mapLine(test.line, hunks, true); end != test.end {
+			t.Errorf("line %d: expected end %d, got %d", test.line, test.end, end)
+		}
+	}
+}
+
+func TestChangedLines(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %s", args, output)
+		}
+	}
+	write := func(content string) {
+		err := os.WriteFile(filepath.Join(dir, "part.scad"), []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q")
+	write("a=1;\nb=2;\nc=3;\nd=4;\n")
+	run("add", "part.scad")
+	run("commit", "-q", "-m", "initial")
+
+	// stage a change to line 3, then add an unstaged line at the start
+	write("a=1;\nb=2;\nc=30;\nd=4;\n")
+	run("add", "part.scad")
+	write("z=0;\na=1;\nb=2;\nc=30;\nd=4;\n")
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(root, "part.scad")
+	for _, test := range []struct {
+		rev      string
+		staged   bool
+		expected []config.LineRange
+	}{
+		{"HEAD", false, []config.LineRange{{Start: 1, End: 1}, {Start: 4, End: 4}}},
+		{"", true, []config.LineRange{{Start: 4, End: 4}}},
+	} {
+		lines, err := ChangedLines(dir, test.rev, test.staged)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(lines, map[string][]config.LineRange{path: test.expected}) {
+			t.Errorf("rev %q, staged %t: expected %v, got %v", test.rev, test.staged, test.expected, lines)
+		}
+	}
+
+	// a revision that looks like an option is rejected as a revision
+	_, err = ChangedLines(dir, "--output=diff.txt", false)
+	if err == nil {
+		t.Error("expected an error for an invalid revision")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "diff.txt")); err == nil {
+		t.Error("revision was used as an option")
+	}
+}
@@ -0,0 +1,123 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package gitdiff
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// StagedFile is a file with changes that are staged for the next commit.
+type StagedFile struct {
+	Path string // absolute path of the file in the working tree
+	Mode string // file mode in the index, e.g. "100644"
+	Hash string // object ID of the staged content
+	root string // root of the repository
+}
+
+// name returns the path of the file relative to the root of the repository.
+func (f *StagedFile) name() (string, error) {
+	name, err := filepath.Rel(f.root, f.Path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(name), nil
+}
+
+// StagedFiles returns the regular files that are added, copied, modified or
+// renamed in the index of the repository containing dir. Staged content may
+// differ from the working tree, if not all changes are staged.
+func StagedFiles(dir string) ([]StagedFile, error) {
+	root, err := Toplevel(dir)
+	if err != nil {
+		return nil, err
+	}
+	output, err := git(dir, "diff", "--cached", "--raw", "-z", "--no-abbrev", "--no-renames", "--diff-filter=ACMR")
+	if err != nil {
+		return nil, err
+	}
+
+	// each file is ":oldmode newmode oldhash newhash status", followed by the path
+	var files []StagedFile
+	fields := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		info := strings.Fields(strings.TrimPrefix(fields[i], ":"))
+		if len(info) != 5 {
+			return nil, fmt.Errorf("unexpected output from git diff: %q", fields[i])
+		}
+		mode := info[1]
+		if mode != "100644" && mode != "100755" {
+			// symbolic links and submodules aren't formatted
+			continue
+		}
+		files = append(files, StagedFile{
+			Path: filepath.Join(root, filepath.FromSlash(fields[i+1])),
+			Mode: mode,
+			Hash: info[3],
+			root: root,
+		})
+	}
+	return files, nil
+}
+
+// ReadStaged returns the staged content of a file.
+func ReadStaged(file StagedFile) ([]byte, error) {
+	return git(file.root, "cat-file", "blob", file.Hash)
+}
+
+// UpdateStaged replaces the staged content of a file, without changing the
+// file in the working tree.
+func UpdateStaged(file StagedFile, content []byte) error {
+	output, err := gitWithInput(file.root, content, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return err
+	}
+	name, err := file.name()
+	if err != nil {
+		return err
+	}
+	hash := strings.TrimSpace(string(output))
+	_, err = git(file.root, "update-index", "--cacheinfo", fmt.Sprintf("%s,%s,%s", file.Mode, hash, name))
+	return err
+}
+
+// HooksDir returns the directory containing the hooks of the repository
+// containing dir.
+func HooksDir(dir string) (string, error) {
+	output, err := git(dir, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", err
+	}
+	hooksDir := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(dir, hooksDir)
+	}
+	return hooksDir, nil
+}
+
+// Toplevel returns the root of the working tree of the repository containing dir.
+func Toplevel(dir string) (string, error) {
+	output, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(output)), nil
+}
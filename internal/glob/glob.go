@@ -0,0 +1,216 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+// Package glob implements glob patterns for matching slash separated paths.
+//
+// The following syntax is supported:
+//
+//	?       matches any single character, except "/"
+//	*       matches any sequence of characters, except "/"
+//	**      matches any sequence of characters, including "/"
+//	[abc]   matches any one of the characters in the brackets ([!abc] or [^abc] to negate)
+//	{a,b}   matches any one of the comma separated alternatives
+//	{n..m}  matches any integer between n and m (inclusive)
+//	\x      matches the character x
+//
+// A "**" path segment also matches zero segments, so "a/**/b" matches "a/b".
+package glob
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var numericRangeRegex = regexp.MustCompile(`^\{([+-]?\d+)\.\.([+-]?\d+)\}`)
+
+type Glob struct {
+	pattern string
+	regex   *regexp.Regexp
+	ranges  []numericRange // numeric ranges, in the order of the regex capture groups
+}
+
+type numericRange struct {
+	min int
+	max int
+}
+
+// Compile parses a glob pattern.
+func Compile(pattern string) (*Glob, error) {
+	c := &compiler{pattern: pattern}
+	expr, err := c.compile()
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+	regex, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+	return &Glob{pattern: pattern, regex: regex, ranges: c.ranges}, nil
+}
+
+// MustCompile is like Compile, but panics if the pattern is invalid.
+func MustCompile(pattern string) *Glob {
+	g, err := Compile(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// Match returns true if the slash separated path matches the pattern.
+func (g *Glob) Match(path string) bool {
+	if len(g.ranges) == 0 {
+		return g.regex.MatchString(path)
+	}
+	matches := g.regex.FindStringSubmatch(path)
+	if matches == nil {
+		return false
+	}
+	for i, r := range g.ranges {
+		n, err := strconv.Atoi(matches[i+1])
+		if err != nil || n < r.min || n > r.max {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *Glob) String() string {
+	return g.pattern
+}
+
+type compiler struct {
+	pattern    string
+	pos        int
+	braceDepth int
+	ranges     []numericRange
+}
+
+func (c *compiler) compile() (string, error) {
+	var sb strings.Builder
+	for c.pos < len(c.pattern) {
+		ch := c.pattern[c.pos]
+		switch {
+		case ch == '*':
+			sb.WriteString(c.star())
+		case ch == '?':
+			sb.WriteString("[^/]")
+			c.pos++
+		case ch == '[':
+			class, err := c.class()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(class)
+		case ch == '{' && numericRangeRegex.MatchString(c.pattern[c.pos:]):
+			sb.WriteString(c.numericRange())
+		case ch == '{':
+			sb.WriteString("(?:")
+			c.braceDepth++
+			c.pos++
+		case ch == '}' && c.braceDepth > 0:
+			sb.WriteString(")")
+			c.braceDepth--
+			c.pos++
+		case ch == ',' && c.braceDepth > 0:
+			sb.WriteString("|")
+			c.pos++
+		case ch == '\\' && c.pos+1 < len(c.pattern):
+			sb.WriteString(regexp.QuoteMeta(c.pattern[c.pos+1 : c.pos+2]))
+			c.pos += 2
+		default:
+			sb.WriteString(regexp.QuoteMeta(c.pattern[c.pos : c.pos+1]))
+			c.pos++
+		}
+	}
+	if c.braceDepth > 0 {
+		return "", fmt.Errorf("unterminated '{'")
+	}
+	return sb.String(), nil
+}
+
+// star compiles "*" or "**" at the current position.
+func (c *compiler) star() string {
+	if !strings.HasPrefix(c.pattern[c.pos:], "**") {
+		c.pos++
+		return "[^/]*"
+	}
+	atSegmentStart := c.pos == 0 || c.pattern[c.pos-1] == '/'
+	c.pos += 2
+	for c.pos < len(c.pattern) && c.pattern[c.pos] == '*' {
+		c.pos++
+	}
+	if atSegmentStart && c.pos < len(c.pattern) && c.pattern[c.pos] == '/' {
+		// "**/" matches zero or more directories
+		c.pos++
+		return "(?:.*/)?"
+	}
+	return ".*"
+}
+
+// numericRange compiles a "{n..m}" range at the current position. The number is
+// captured, so that Match can check that it is within the range.
+func (c *compiler) numericRange() string {
+	matches := numericRangeRegex.FindStringSubmatch(c.pattern[c.pos:])
+	c.pos += len(matches[0])
+	lower, _ := strconv.Atoi(matches[1])
+	upper, _ := strconv.Atoi(matches[2])
+	if lower > upper {
+		lower, upper = upper, lower
+	}
+	c.ranges = append(c.ranges, numericRange{min: lower, max: upper})
+	return `([+-]?\d+)`
+}
+
+// class compiles a "[...]" character class at the current position. If the
+// class is not terminated, the "[" is treated as a literal character.
+func (c *compiler) class() (string, error) {
+	end := c.pos + 1
+	if end < len(c.pattern) && (c.pattern[end] == '!' || c.pattern[end] == '^') {
+		end++
+	}
+	if end < len(c.pattern) && c.pattern[end] == ']' {
+		end++
+	}
+	for end < len(c.pattern) && c.pattern[end] != ']' {
+		end++
+	}
+	if end >= len(c.pattern) {
+		c.pos++
+		return regexp.QuoteMeta("["), nil
+	}
+
+	body := c.pattern[c.pos+1 : end]
+	c.pos = end + 1
+	var sb strings.Builder
+	sb.WriteString("[")
+	if strings.HasPrefix(body, "!") || strings.HasPrefix(body, "^") {
+		sb.WriteString("^/")
+		body = body[1:]
+	}
+	for _, r := range body {
+		if r == '\\' || r == '[' || r == ']' || r == '^' {
+			sb.WriteString(`\`)
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteString("]")
+	return sb.String(), nil
+}
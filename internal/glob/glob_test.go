@@ -0,0 +1,68 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"*.scad", "part.scad", true},
+		{"*.scad", "lib/part.scad", false},
+		{"lib/*.scad", "lib/part.scad", true},
+		{"lib/**", "lib/a/b/part.scad", true},
+		{"lib/**/part.scad", "lib/part.scad", true},
+		{"lib/**/part.scad", "lib/a/b/part.scad", true},
+		{"**/part.scad", "part.scad", true},
+		{"**/part.scad", "a/part.scad", true},
+		{"part?.scad", "part1.scad", true},
+		{"part?.scad", "part/.scad", false},
+		{"part[0-9].scad", "part5.scad", true},
+		{"part[!0-9].scad", "part5.scad", false},
+		{"*.{scad,txt}", "a.txt", true},
+		{"*.{scad,txt}", "a.stl", false},
+		{`a\*.scad`, "a*.scad", true},
+		{`a\*.scad`, "ab.scad", false},
+		{"a.scad", "aXscad", false},
+		{"[abc", "[abc", true},
+		{"part{1..3}.scad", "part2.scad", true},
+		{"part{1..3}.scad", "part4.scad", false},
+		{"part{-5..-1}.scad", "part-3.scad", true},
+		{"{a,b}{1..2}", "b2", true},
+	}
+	for _, test := range tests {
+		g, err := Compile(test.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Match(test.path) != test.match {
+			t.Errorf("pattern %q, path %q: expected match=%v", test.pattern, test.path, test.match)
+		}
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	_, err := Compile("{a,b")
+	if err == nil {
+		t.Fatal("expected error for unterminated brace")
+	}
+}
@@ -0,0 +1,255 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+// Package ignore matches paths against ignore files that use the same pattern
+// syntax and semantics as .gitignore files.
+//
+// Ignore files are read from the directory containing a path and each of its
+// parent directories, up to the root of the git repository containing the path
+// (or the root of the filesystem, if the path is not in a repository). Patterns
+// in deeper files take precedence over patterns in shallower files, and later
+// patterns in a file take precedence over earlier ones. As with git, a path
+// can't be re-included by a negated pattern if one of its parent directories
+// is ignored.
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hugheaves/scadformat/internal/glob"
+)
+
+// Rule is a single pattern from an ignore file.
+type Rule struct {
+	pattern  string // the pattern, as written in the ignore file
+	source   string // ignore file and line number
+	dir      string // directory containing the ignore file
+	glob     *glob.Glob
+	negate   bool // pattern starts with "!"
+	dirOnly  bool // pattern ends with "/", so only matches directories
+	anchored bool // pattern contains a "/", so is matched relative to dir
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s: %s", r.source, r.pattern)
+}
+
+// match returns true if the pattern matches the path, which must be in (or
+// below) the directory containing the ignore file.
+func (r *Rule) match(absPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		return r.glob.Match(filepath.Base(absPath))
+	}
+	relPath, err := filepath.Rel(r.dir, absPath)
+	if err != nil {
+		return false
+	}
+	return r.glob.Match(filepath.ToSlash(relPath))
+}
+
+// dirInfo is the ignore rules in a directory, and whether the directory is the
+// root of a git repository.
+type dirInfo struct {
+	rules    []*Rule
+	repoRoot bool
+}
+
+// Matcher checks paths against the rules in ignore files. Ignore files are
+// cached, so each file is only read once. A Matcher is safe for concurrent use.
+type Matcher struct {
+	fileNames []string
+	mutex     sync.Mutex
+	dirs      map[string]*dirInfo
+}
+
+// NewMatcher returns a Matcher that reads ignore files with the given names.
+// When several names are given, the rules in each directory are applied in
+// the order of the names.
+func NewMatcher(fileNames ...string) *Matcher {
+	return &Matcher{
+		fileNames: fileNames,
+		dirs:      make(map[string]*dirInfo),
+	}
+}
+
+// Match returns the rule that causes a path to be ignored, or nil if the path
+// is not ignored. isDir specifies whether the path is a directory.
+func (m *Matcher) Match(path string, isDir bool) (*Rule, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// find the directories that may contain ignore files, from the top down
+	var dirs []string
+	for dir := filepath.Dir(absPath); ; dir = filepath.Dir(dir) {
+		info, err := m.load(dir)
+		if err != nil {
+			return nil, err
+		}
+		dirs = append([]string{dir}, dirs...)
+		if info.repoRoot || filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	// check each directory below the top, and then the path itself
+	var rules []*Rule
+	for i, dir := range dirs {
+		rules = append(rules, m.dirs[dir].rules...)
+		if i+1 < len(dirs) {
+			if rule := matchRules(rules, dirs[i+1], true); rule != nil {
+				return rule, nil
+			}
+		}
+	}
+	return matchRules(rules, absPath, isDir), nil
+}
+
+// matchRules returns the last rule that matches the path, or nil if no rule
+// matches or the last matching rule is negated.
+func matchRules(rules []*Rule, absPath string, isDir bool) *Rule {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].match(absPath, isDir) {
+			if rules[i].negate {
+				return nil
+			}
+			return rules[i]
+		}
+	}
+	return nil
+}
+
+func (m *Matcher) load(dir string) (*dirInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if info, ok := m.dirs[dir]; ok {
+		return info, nil
+	}
+	info := &dirInfo{}
+	for _, fileName := range m.fileNames {
+		rules, err := readIgnoreFile(filepath.Join(dir, fileName))
+		if err != nil {
+			return nil, err
+		}
+		info.rules = append(info.rules, rules...)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		info.repoRoot = true
+	}
+	m.dirs[dir] = info
+	return info, nil
+}
+
+// readIgnoreFile parses an ignore file, returning no rules if the file does not exist.
+func readIgnoreFile(path string) ([]*Rule, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []*Rule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		rule, err := parseRule(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		if rule == nil {
+			continue
+		}
+		rule.source = fmt.Sprintf("%s:%d", path, lineNumber)
+		rule.dir = filepath.Dir(path)
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// parseRule parses a line of an ignore file, returning nil for blank lines and
+// comments.
+func parseRule(line string) (*Rule, error) {
+	line = strings.TrimSuffix(line, "\r")
+	// trailing spaces are ignored, unless they are escaped with a backslash
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, nil
+	}
+
+	rule := &Rule{pattern: line}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	// a pattern is matched relative to the ignore file if it contains a "/"
+	// anywhere other than at the end
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return nil, nil
+	}
+
+	g, err := glob.Compile(escapeBraces(line))
+	if err != nil {
+		return nil, err
+	}
+	rule.glob = g
+	return rule, nil
+}
+
+// escapeBraces escapes the "{" characters in a pattern, as braces are not
+// special in ignore files.
+func escapeBraces(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			sb.WriteString(pattern[i:min(i+2, len(pattern))])
+			i++
+		case '{':
+			sb.WriteString("\\{")
+		default:
+			sb.WriteByte(pattern[i])
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,95 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(content), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	root := t.TempDir()
+	// rules above the repository root are not used
+	writeFile(t, filepath.Join(root, ".ignore"), "*.scad\n")
+	repo := filepath.Join(root, "repo")
+	writeFile(t, filepath.Join(repo, ".git", "HEAD"), "")
+	writeFile(t, filepath.Join(repo, ".ignore"), `
+# comment
+*.gen.scad
+!keep.gen.scad
+/top.scad
+build/
+docs/**/*.scad
+vendor/
+!vendor/mine.scad
+\#hash.scad
+trailing.scad   
+{a,b}.scad
+`)
+	writeFile(t, filepath.Join(repo, "sub", ".ignore"), "!other.gen.scad\nlocal.scad\n")
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"main.scad", false, false},
+		{"part.gen.scad", false, true},
+		{"deep/dir/part.gen.scad", false, true},
+		{"keep.gen.scad", false, false},
+		{"top.scad", false, true},
+		{"sub/top.scad", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"sub/build/out.scad", false, true},
+		{"docs/a.scad", false, true},
+		{"docs/x/y/a.scad", false, true},
+		{"src/docs/a.scad", false, false},
+		{"vendor/mine.scad", false, true},
+		{"#hash.scad", false, true},
+		{"trailing.scad", false, true},
+		{"a.scad", false, false},
+		{"{a,b}.scad", false, true},
+		{"sub/other.gen.scad", false, false},
+		{"sub/local.scad", false, true},
+		{"local.scad", false, false},
+	}
+	m := NewMatcher(".ignore")
+	for _, test := range tests {
+		rule, err := m.Match(filepath.Join(repo, filepath.FromSlash(test.path)), test.isDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (rule != nil) != test.ignored {
+			t.Errorf("%s: expected ignored %t, got rule %v", test.path, test.ignored, rule)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"sync"
+)
+
+// conn reads and writes JSON-RPC messages, using the LSP base protocol: each
+// message is preceded by a header containing its length.
+type conn struct {
+	reader *bufio.Reader
+	writer io.Writer
+	mutex  sync.Mutex // serializes writes
+}
+
+func newConn(r io.Reader, w io.Writer) *conn {
+	return &conn{reader: bufio.NewReader(r), writer: w}
+}
+
+// read returns the content of the next message.
+func (c *conn) read() ([]byte, error) {
+	header, err := textproto.NewReader(c.reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length header %q", header.Get("Content-Length"))
+	}
+	content := make([]byte, length)
+	_, err = io.ReadFull(c.reader, content)
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// write sends a message, encoded as JSON.
+func (c *conn) write(message any) error {
+	content, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, err = fmt.Fprintf(c.writer, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	return err
+}
@@ -0,0 +1,141 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package lsp
+
+import (
+	"strings"
+	"unicode/utf16"
+
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+)
+
+// lineEdits returns the edits that change before into after. Each edit replaces
+// a block of whole lines, so that unchanged lines (and the editor's cursor
+// position in them) are not disturbed.
+func lineEdits(before string, after string) []textEdit {
+	lines := strings.SplitAfter(before, "\n")
+	// lineStart returns the position of the start of a line (numbered from 0)
+	lineStart := func(line int) position {
+		if line < len(lines) {
+			return position{Line: line}
+		}
+		// the end of a document without a final newline
+		last := len(lines) - 1
+		return position{Line: last, Character: utf16Length(lines[last])}
+	}
+
+	// merge adjacent edits (e.g. a deletion followed by an insertion) into
+	// blocks, which are numbered by line
+	type block struct {
+		start, end int
+		newText    string
+	}
+	var blocks []*block
+	for _, edit := range myers.ComputeEdits(span.URIFromPath(""), before, after) {
+		start := edit.Span.Start().Line() - 1
+		end := edit.Span.End().Line() - 1
+		if len(blocks) > 0 && blocks[len(blocks)-1].end == start {
+			blocks[len(blocks)-1].end = end
+			blocks[len(blocks)-1].newText += edit.NewText
+		} else {
+			blocks = append(blocks, &block{start: start, end: end, newText: edit.NewText})
+		}
+	}
+
+	var edits []textEdit
+	for _, b := range blocks {
+		oldLines := lines[b.start:min(b.end, len(lines))]
+		newLines := strings.SplitAfter(b.newText, "\n")
+		if newLines[len(newLines)-1] == "" {
+			newLines = newLines[:len(newLines)-1]
+		}
+		if len(oldLines) != len(newLines) {
+			edits = append(edits, textEdit{
+				Range:   textRange{Start: lineStart(b.start), End: lineStart(b.end)},
+				NewText: b.newText,
+			})
+			continue
+		}
+		// when lines are changed but not added or removed, edit each line
+		// separately, so that range formatting can select individual lines
+		for i := range oldLines {
+			if oldLines[i] != newLines[i] {
+				edits = append(edits, textEdit{
+					Range:   textRange{Start: lineStart(b.start + i), End: lineStart(b.start + i + 1)},
+					NewText: newLines[i],
+				})
+			}
+		}
+	}
+	return edits
+}
+
+// editsInLines returns the edits that change any of the lines from firstLine to
+// lastLine (inclusive).
+func editsInLines(edits []textEdit, firstLine int, lastLine int) []textEdit {
+	var selected []textEdit
+	for _, edit := range edits {
+		start, end := edit.Range.Start.Line, edit.Range.End.Line
+		if edit.Range.End.Character > 0 {
+			// the edit ends part way through the last line of a document
+			end++
+		}
+		if start <= lastLine && (end > firstLine || (start == end && start >= firstLine)) {
+			selected = append(selected, edit)
+		}
+	}
+	return selected
+}
+
+// utf16Length returns the length of a string in UTF-16 code units, which is
+// how LSP measures positions within a line.
+func utf16Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// utf16Column converts a column measured in characters to UTF-16 code units.
+func utf16Column(line string, column int) int {
+	runes := []rune(line)
+	if column > len(runes) {
+		column = len(runes)
+	}
+	return len(utf16.Encode(runes[:column]))
+}
+
+// offsetOf converts a position to a byte offset in the text. Positions beyond
+// the end of a line or of the text are moved to the end of the line or text.
+func offsetOf(text string, pos position) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		next := strings.IndexByte(text[offset:], '\n')
+		if next < 0 {
+			return len(text)
+		}
+		offset += next + 1
+	}
+	units := 0
+	for i, r := range text[offset:] {
+		if r == '\n' || units >= pos.Character {
+			return offset + i
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return len(text)
+}
@@ -0,0 +1,244 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package lsp
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// openSCADPathEnv is the environment variable containing the library
+// directories that OpenSCAD searches for include and use files.
+const openSCADPathEnv = "OPENSCADPATH"
+
+// workspace loads and indexes the files needed to answer a navigation request.
+// Open documents are used in preference to the files on disk, so that unsaved
+// changes are taken into account.
+type workspace struct {
+	server *Server
+	files  map[string]*fileIndex // indexed files, by URI
+}
+
+func (s *Server) newWorkspace() *workspace {
+	return &workspace{server: s, files: make(map[string]*fileIndex)}
+}
+
+// open returns the index of an open document.
+func (w *workspace) open(uri string) (*fileIndex, error) {
+	if file, ok := w.files[uri]; ok {
+		return file, nil
+	}
+	doc, err := w.server.document(uri)
+	if err != nil {
+		return nil, err
+	}
+	file := indexFile(uri, uriToPath(uri), doc.text)
+	w.files[uri] = file
+	return file, nil
+}
+
+// load returns the index of a file, or nil if the file can't be read.
+func (w *workspace) load(path string) *fileIndex {
+	for uri := range w.server.documents {
+		if uriToPath(uri) == path {
+			file, _ := w.open(uri)
+			return file
+		}
+	}
+	uri := pathToURI(path)
+	if file, ok := w.files[uri]; ok {
+		return file
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	file := indexFile(uri, path, string(content))
+	w.files[uri] = file
+	return file
+}
+
+// importFile returns the index of a file named in an include or use statement.
+// As in OpenSCAD, the file is searched for in the directory of the importing
+// file, and then in the OPENSCADPATH directories.
+func (w *workspace) importFile(from *fileIndex, fileName string) *fileIndex {
+	name := filepath.FromSlash(fileName)
+	if filepath.IsAbs(name) {
+		return w.load(name)
+	}
+	var dirs []string
+	if from.path != "" {
+		dirs = append(dirs, filepath.Dir(from.path))
+	}
+	dirs = append(dirs, filepath.SplitList(os.Getenv(openSCADPathEnv))...)
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if file := w.load(filepath.Join(dir, name)); file != nil {
+			return file
+		}
+	}
+	return nil
+}
+
+// imports returns the files imported by a file, directly or indirectly.
+func (w *workspace) imports(file *fileIndex, visited map[string]bool) []*fileIndex {
+	var files []*fileIndex
+	for _, imp := range file.imports {
+		imported := w.importFile(file, imp.fileName)
+		if imported == nil || visited[imported.uri] {
+			continue
+		}
+		visited[imported.uri] = true
+		files = append(files, imported)
+		files = append(files, w.imports(imported, visited)...)
+	}
+	return files
+}
+
+// symbolAt returns the definition of the name at a position in a file, or nil
+// if there is no name at the position, or its definition can't be found.
+func (w *workspace) symbolAt(file *fileIndex, pos position) *symbol {
+	for _, ref := range file.refs {
+		if ref.rng.contains(pos) {
+			return w.resolve(file, ref)
+		}
+	}
+	for _, s := range file.symbols {
+		if s.nameRange.contains(pos) {
+			return s
+		}
+	}
+	return nil
+}
+
+// resolve returns the definition of a reference, or nil if it can't be found.
+// Function values assigned to variables can be called like functions, so calls
+// that don't resolve to a function are resolved as variables.
+func (w *workspace) resolve(file *fileIndex, ref *reference) *symbol {
+	s := w.lookup(file, ref.scope, ref.name, ref.kind)
+	if s == nil && ref.kind == kindFunction {
+		s = w.lookup(file, ref.scope, ref.name, kindVariable)
+	}
+	return s
+}
+
+func (w *workspace) lookup(file *fileIndex, sc *scope, name string, kind symbolKind) *symbol {
+	for ; sc != nil; sc = sc.parent {
+		if s := sc.lookup(name, kind); s != nil {
+			return s
+		}
+	}
+	return w.lookupImported(file, name, kind, true, make(map[string]bool))
+}
+
+// lookupImported finds a top level definition in the files imported by a file.
+// Included files behave as if their content was part of the including file,
+// but "use" only imports modules and functions, and the files used by a used
+// file are not imported. Later imports take precedence over earlier ones.
+func (w *workspace) lookupImported(file *fileIndex, name string, kind symbolKind, followUses bool, visited map[string]bool) *symbol {
+	visited[file.uri] = true
+	for i := len(file.imports) - 1; i >= 0; i-- {
+		imp := file.imports[i]
+		if imp.use && (!followUses || kind == kindVariable) {
+			continue
+		}
+		imported := w.importFile(file, imp.fileName)
+		if imported == nil || visited[imported.uri] {
+			continue
+		}
+		if s := imported.global.lookup(name, kind); s != nil {
+			return s
+		}
+		if s := w.lookupImported(imported, name, kind, followUses && !imp.use, visited); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// references returns the locations of the references to a definition. The
+// file containing the definition, the files it imports, and the open documents
+// (which may import it) are searched.
+func (w *workspace) references(target *symbol, includeDeclaration bool) []location {
+	files := []*fileIndex{target.file}
+	files = append(files, w.imports(target.file, map[string]bool{target.file.uri: true})...)
+	for uri := range w.server.documents {
+		file, err := w.open(uri)
+		if err == nil {
+			files = append(files, file)
+		}
+	}
+
+	locations := []location{}
+	searched := make(map[string]bool)
+	for _, file := range files {
+		if searched[file.uri] {
+			continue
+		}
+		searched[file.uri] = true
+		if includeDeclaration && file == target.file {
+			locations = append(locations, location{URI: file.uri, Range: target.nameRange})
+		}
+		for _, ref := range file.refs {
+			if ref.name == target.name && w.resolve(file, ref) == target {
+				locations = append(locations, location{URI: file.uri, Range: ref.rng})
+			}
+		}
+	}
+	return locations
+}
+
+// documentSymbols returns the outline of a document.
+func documentSymbols(symbols []*symbol) []documentSymbol {
+	result := []documentSymbol{}
+	for _, s := range symbols {
+		result = append(result, documentSymbol{
+			Name:           s.name,
+			Kind:           s.kind.lspKind(),
+			Range:          s.fullRange,
+			SelectionRange: s.nameRange,
+			Children:       documentSymbols(s.children),
+		})
+	}
+	return result
+}
+
+// contains returns true if a position is within the range, or at its end.
+func (r textRange) contains(pos position) bool {
+	return !pos.before(r.Start) && !r.End.before(pos)
+}
+
+func (p position) before(other position) bool {
+	return p.Line < other.Line || (p.Line == other.Line && p.Character < other.Character)
+}
+
+// pathToURI returns the "file:" URI of a file path.
+func pathToURI(path string) string {
+	path = filepath.ToSlash(path)
+	if runtime.GOOS == "windows" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	u := url.URL{Scheme: "file", Path: path}
+	return u.String()
+}
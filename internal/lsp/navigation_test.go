@@ -0,0 +1,104 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package lsp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNavigation(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"lib.scad":  "libVar = 1;\nmodule box(size) {\n  cube(size);\n}\n",
+		"used.scad": "usedVar = 2;\nfunction double(x) = x * 2;\n",
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	libURI := pathToURI(filepath.Join(dir, "lib.scad"))
+	usedURI := pathToURI(filepath.Join(dir, "used.scad"))
+	uri := pathToURI(filepath.Join(dir, "main.scad"))
+	text := "include <lib.scad>\nuse <used.scad>\nw = double(libVar);\nbox(w);\nz = usedVar;\n" +
+		"module m(a) {\n  let (b = a) translate([b, 0, 0]) box(a);\n}\n"
+
+	c := startServer(t)
+	c.request("initialize", map[string]any{"capabilities": map[string]any{}}, nil)
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "version": 1, "text": text}})
+	c.diagnostics()
+
+	nameRange := func(line int, character int, length int) textRange {
+		return textRange{Start: position{Line: line, Character: character}, End: position{Line: line, Character: character + length}}
+	}
+	for _, test := range []struct {
+		pos      position
+		expected *location
+	}{
+		{position{Line: 2, Character: 5}, &location{URI: usedURI, Range: nameRange(1, 9, 6)}},
+		{position{Line: 2, Character: 11}, &location{URI: libURI, Range: nameRange(0, 0, 6)}},
+		{position{Line: 3, Character: 4}, &location{URI: uri, Range: nameRange(2, 0, 1)}},
+		{position{Line: 4, Character: 6}, nil}, // "use" doesn't import variables
+		{position{Line: 6, Character: 25}, &location{URI: uri, Range: nameRange(6, 7, 1)}},
+		{position{Line: 6, Character: 40}, &location{URI: uri, Range: nameRange(5, 9, 1)}},
+	} {
+		var result *location
+		c.request("textDocument/definition", map[string]any{"textDocument": map[string]any{"uri": uri}, "position": test.pos}, &result)
+		if (result == nil) != (test.expected == nil) || (result != nil && *result != *test.expected) {
+			t.Errorf("definition at %v: expected %v, got %v", test.pos, test.expected, result)
+		}
+	}
+
+	var locations []location
+	c.request("textDocument/references", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     position{Line: 3, Character: 1},
+		"context":      map[string]any{"includeDeclaration": true},
+	}, &locations)
+	expected := []location{
+		{URI: libURI, Range: nameRange(1, 7, 3)},
+		{URI: uri, Range: nameRange(3, 0, 3)},
+		{URI: uri, Range: nameRange(6, 35, 3)},
+	}
+	if len(locations) != len(expected) {
+		t.Fatalf("references: expected %v, got %v", expected, locations)
+	}
+	for _, l := range expected {
+		found := false
+		for _, r := range locations {
+			found = found || r == l
+		}
+		if !found {
+			t.Errorf("references: expected %v in %v", l, locations)
+		}
+	}
+
+	var symbols []documentSymbol
+	c.request("textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}}, &symbols)
+	var names []string
+	for _, s := range symbols {
+		names = append(names, s.Name)
+	}
+	if len(symbols) != 3 || names[0] != "w" || names[2] != "m" || symbols[2].Kind != lspSymbolModule {
+		t.Errorf("expected symbols w, z and m, got %v", symbols)
+	}
+}
@@ -0,0 +1,417 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+// Package lsp implements a Language Server Protocol server, which formats
+// OpenSCAD documents, reports syntax errors as the user types, and provides
+// navigation between the definitions and uses of modules, functions and
+// variables.
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/hugheaves/scadformat/internal/formatter"
+	"github.com/hugheaves/scadformat/internal/parser"
+	"go.uber.org/zap"
+)
+
+// Server is a language server that communicates with a single client. Open
+// documents are kept in memory, and each document is formatted using the
+// project configuration for its path.
+type Server struct {
+	formatter   *formatter.Formatter
+	conn        *conn
+	version     string
+	documents   map[string]*document // open documents, by URI
+	initialized bool                 // "initialize" request was received
+	shutdown    bool                 // "shutdown" request was received
+}
+
+type document struct {
+	text    string
+	version int
+}
+
+// NewServer returns a server that reads messages from r and writes messages to w.
+func NewServer(f *formatter.Formatter, r io.Reader, w io.Writer, version string) *Server {
+	return &Server{
+		formatter: f,
+		conn:      newConn(r, w),
+		version:   version,
+		documents: make(map[string]*document),
+	}
+}
+
+// Run handles messages until the client sends the "exit" notification or closes
+// the connection. An error is returned if the client exits without first
+// requesting a shutdown.
+func (s *Server) Run() error {
+	for {
+		content, err := s.conn.read()
+		if errors.Is(err, io.EOF) && s.shutdown {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		err = json.Unmarshal(content, &req)
+		if err != nil {
+			s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()})
+			continue
+		}
+		zap.S().Debugf("received %s", req.Method)
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("client exited without shutting down the server")
+			}
+			return nil
+		}
+
+		result, err := s.handle(&req)
+		if req.ID == nil {
+			// notifications don't have responses
+			if err != nil {
+				zap.S().Errorf("%s: %s", req.Method, err)
+			}
+			continue
+		}
+		s.reply(req.ID, result, err)
+	}
+}
+
+func (s *Server) reply(id json.RawMessage, result any, err error) {
+	resp := response{JSONRPC: "2.0", ID: id}
+	if id == nil {
+		resp.ID = json.RawMessage("null")
+	}
+	if err == nil {
+		resp.Result, err = json.Marshal(result)
+	}
+	if err != nil {
+		var respErr *responseError
+		if !errors.As(err, &respErr) {
+			respErr = &responseError{Code: codeRequestFailed, Message: err.Error()}
+		}
+		resp.Result = nil
+		resp.Error = respErr
+	}
+	err = s.conn.write(resp)
+	if err != nil {
+		zap.S().Errorf("failed to write response: %s", err)
+	}
+}
+
+func (s *Server) notify(method string, params any) {
+	err := s.conn.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+	if err != nil {
+		zap.S().Errorf("failed to write %s notification: %s", method, err)
+	}
+}
+
+// handle handles a request or notification, returning the result.
+func (s *Server) handle(req *request) (any, error) {
+	if req.Method == "initialize" {
+		s.initialized = true
+		return s.initialize(), nil
+	}
+	if !s.initialized {
+		return nil, &responseError{Code: codeServerNotInitialized, Message: "server not initialized"}
+	}
+	if s.shutdown {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server is shutting down"}
+	}
+
+	switch req.Method {
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenTextDocumentParams
+		return nil, handleParams(req, &params, func() error {
+			s.documents[params.TextDocument.URI] = &document{text: params.TextDocument.Text, version: params.TextDocument.Version}
+			s.publishDiagnostics(params.TextDocument.URI)
+			return nil
+		})
+	case "textDocument/didChange":
+		var params didChangeTextDocumentParams
+		return nil, handleParams(req, &params, func() error {
+			return s.didChange(&params)
+		})
+	case "textDocument/didClose":
+		var params didCloseTextDocumentParams
+		return nil, handleParams(req, &params, func() error {
+			delete(s.documents, params.TextDocument.URI)
+			s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []diagnostic{}})
+			return nil
+		})
+	case "textDocument/formatting":
+		var params documentFormattingParams
+		var edits []textEdit
+		return &edits, handleParams(req, &params, func() (err error) {
+			edits, err = s.formatLines(params.TextDocument.URI, 0, -1)
+			return err
+		})
+	case "textDocument/rangeFormatting":
+		var params documentRangeFormattingParams
+		var edits []textEdit
+		return &edits, handleParams(req, &params, func() (err error) {
+			lastLine := params.Range.End.Line
+			if params.Range.End.Character == 0 && lastLine > params.Range.Start.Line {
+				// the range ends at the start of a line, so doesn't include it
+				lastLine--
+			}
+			edits, err = s.formatLines(params.TextDocument.URI, params.Range.Start.Line, lastLine)
+			return err
+		})
+	case "textDocument/onTypeFormatting":
+		var params documentOnTypeFormattingParams
+		var edits []textEdit
+		return &edits, handleParams(req, &params, func() (err error) {
+			edits, err = s.onTypeFormatting(&params)
+			return err
+		})
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		var symbols []documentSymbol
+		return &symbols, handleParams(req, &params, func() error {
+			file, err := s.newWorkspace().open(params.TextDocument.URI)
+			if err == nil {
+				symbols = documentSymbols(file.outline)
+			}
+			return err
+		})
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		var result *location
+		return &result, handleParams(req, &params, func() error {
+			w := s.newWorkspace()
+			file, err := w.open(params.TextDocument.URI)
+			if err != nil {
+				return err
+			}
+			if target := w.symbolAt(file, params.Position); target != nil {
+				result = &location{URI: target.file.uri, Range: target.nameRange}
+			}
+			return nil
+		})
+	case "textDocument/references":
+		var params referenceParams
+		var locations []location
+		return &locations, handleParams(req, &params, func() error {
+			w := s.newWorkspace()
+			file, err := w.open(params.TextDocument.URI)
+			if err != nil {
+				return err
+			}
+			if target := w.symbolAt(file, params.Position); target != nil {
+				locations = w.references(target, params.Context.IncludeDeclaration)
+			}
+			return nil
+		})
+	}
+
+	if req.ID == nil || strings.HasPrefix(req.Method, "$/") {
+		// unsupported notifications (and optional requests) are ignored
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method not supported: %s", req.Method)}
+}
+
+// handleParams decodes the parameters of a request, and then calls handler.
+func handleParams(req *request, params any, handler func() error) error {
+	err := json.Unmarshal(req.Params, params)
+	if err != nil {
+		return &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return handler()
+}
+
+func (s *Server) initialize() *initializeResult {
+	return &initializeResult{
+		Capabilities: serverCapabilities{
+			TextDocumentSync:                textDocumentSyncOptions{OpenClose: true, Change: syncFull},
+			DocumentFormattingProvider:      true,
+			DocumentRangeFormattingProvider: true,
+			DocumentOnTypeFormattingProvider: documentOnTypeFormattingOptions{
+				FirstTriggerCharacter: "}",
+				MoreTriggerCharacter:  []string{";", "\n"},
+			},
+			DocumentSymbolProvider: true,
+			DefinitionProvider:     true,
+			ReferencesProvider:     true,
+		},
+		ServerInfo: serverInfo{Name: "scadformat", Version: s.version},
+	}
+}
+
+func (s *Server) didChange(params *didChangeTextDocumentParams) error {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	for _, change := range params.ContentChanges {
+		if change.Range == nil {
+			doc.text = change.Text
+		} else {
+			start := offsetOf(doc.text, change.Range.Start)
+			end := offsetOf(doc.text, change.Range.End)
+			doc.text = doc.text[:start] + change.Text + doc.text[max(start, end):]
+		}
+	}
+	doc.version = params.TextDocument.Version
+	s.publishDiagnostics(params.TextDocument.URI)
+	return nil
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("document is not open: %s", uri)}
+	}
+	return doc, nil
+}
+
+// formatLines returns the edits that format the lines of a document from
+// firstLine to lastLine (inclusive), or the whole document if lastLine is
+// negative. No edits are returned if the document contains syntax errors, as
+// these are already reported as diagnostics.
+func (s *Server) formatLines(uri string, firstLine int, lastLine int) ([]textEdit, error) {
+	doc, err := s.document(uri)
+	if err != nil {
+		return nil, err
+	}
+	output, err := s.formatter.FormatDocument(uriToPath(uri), []byte(doc.text))
+	var syntaxErr *formatter.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	edits := lineEdits(doc.text, string(output))
+	if lastLine < 0 {
+		return edits, nil
+	}
+	return editsInLines(edits, firstLine, lastLine), nil
+}
+
+// onTypeFormatting formats the code that the user just finished typing: the
+// block ending with a "}", the line ending with a ";", or the line before a
+// newline.
+func (s *Server) onTypeFormatting(params *documentOnTypeFormattingParams) ([]textEdit, error) {
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	line := params.Position.Line
+	switch params.Ch {
+	case "}":
+		return s.formatLines(params.TextDocument.URI, blockStartLine(doc.text, params.Position), line)
+	case "\n":
+		if line == 0 {
+			return nil, nil
+		}
+		return s.formatLines(params.TextDocument.URI, line-1, line-1)
+	default:
+		return s.formatLines(params.TextDocument.URI, line, line)
+	}
+}
+
+// blockStartLine returns the line containing the "{" that matches the last "}"
+// before a position. Braces are matched using the tokens of the document, so
+// braces in strings and comments are ignored.
+func blockStartLine(text string, pos position) int {
+	lines := strings.Split(text, "\n")
+	before := func(token antlr.Token) bool {
+		line := token.GetLine() - 1
+		if line != pos.Line {
+			return line < pos.Line
+		}
+		return utf16Column(strings.TrimSuffix(lines[line], "\r"), token.GetColumn()) < pos.Character
+	}
+
+	startLine := pos.Line
+	var open []int // lines of the unmatched "{" tokens
+	for _, token := range formatter.Tokenize([]byte(text), zap.NewNop()) {
+		if token.GetChannel() != antlr.TokenDefaultChannel || token.GetTokenType() == antlr.TokenEOF || !before(token) {
+			continue
+		}
+		switch token.GetTokenType() {
+		case parser.OpenSCADLexerL_CURLY:
+			open = append(open, token.GetLine()-1)
+		case parser.OpenSCADLexerR_CURLY:
+			startLine = token.GetLine() - 1
+			if len(open) > 0 {
+				startLine = open[len(open)-1]
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	return startLine
+}
+
+// publishDiagnostics sends the syntax errors in a document to the client.
+func (s *Server) publishDiagnostics(uri string) {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return
+	}
+	lines := strings.Split(doc.text, "\n")
+	diagnostics := []diagnostic{}
+	for _, syntaxErr := range formatter.CheckSyntax([]byte(doc.text), zap.L()) {
+		line := min(max(syntaxErr.Line-1, 0), len(lines)-1)
+		lineText := strings.TrimSuffix(lines[line], "\r")
+		start := utf16Column(lineText, syntaxErr.Column)
+		end := utf16Column(lineText, syntaxErr.Column+1)
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    textRange{Start: position{Line: line, Character: start}, End: position{Line: line, Character: end}},
+			Severity: severityError,
+			Source:   "scadformat",
+			Message:  syntaxErr.Msg,
+		})
+	}
+	version := doc.version
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Version: &version, Diagnostics: diagnostics})
+}
+
+// uriToPath returns the file path of a "file:" URI, or "" for other URIs
+// (e.g. unsaved documents), which are formatted with the default settings.
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		// "file:///C:/dir/file.scad" has the path "/C:/dir/file.scad"
+		path = strings.TrimPrefix(path, "/")
+	}
+	return filepath.FromSlash(path)
+}
@@ -0,0 +1,222 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/hugheaves/scadformat/internal/config"
+	"github.com/hugheaves/scadformat/internal/formatter"
+)
+
+// testClient sends messages to a server running in another goroutine.
+type testClient struct {
+	t      *testing.T
+	conn   *conn
+	nextID int
+	done   chan error
+}
+
+func startServer(t *testing.T) *testClient {
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	server := NewServer(formatter.NewFormatter(&config.MainConfig{}), serverIn, serverOut, "test")
+	c := &testClient{t: t, conn: newConn(clientIn, clientOut), done: make(chan error, 1)}
+	go func() {
+		c.done <- server.Run()
+		serverOut.Close()
+	}()
+	return c
+}
+
+// read returns the next message from the server.
+func (c *testClient) read() map[string]json.RawMessage {
+	content, err := c.conn.read()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	var message map[string]json.RawMessage
+	err = json.Unmarshal(content, &message)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return message
+}
+
+// request sends a request, and decodes the result of the response into result.
+func (c *testClient) request(method string, params any, result any) {
+	c.nextID++
+	err := c.conn.write(map[string]any{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	message := c.read()
+	if message["error"] != nil {
+		c.t.Fatalf("%s: %s", method, message["error"])
+	}
+	if result != nil {
+		err = json.Unmarshal(message["result"], result)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+func (c *testClient) notify(method string, params any) {
+	err := c.conn.write(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// diagnostics reads a publishDiagnostics notification.
+func (c *testClient) diagnostics() []diagnostic {
+	message := c.read()
+	var params publishDiagnosticsParams
+	err := json.Unmarshal(message["params"], &params)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return params.Diagnostics
+}
+
+// applyEdits returns the text with the edits applied.
+func applyEdits(text string, edits []textEdit) string {
+	sort.Slice(edits, func(i, j int) bool {
+		return offsetOf(text, edits[i].Range.Start) > offsetOf(text, edits[j].Range.Start)
+	})
+	for _, edit := range edits {
+		start, end := offsetOf(text, edit.Range.Start), offsetOf(text, edit.Range.End)
+		text = text[:start] + edit.NewText + text[end:]
+	}
+	return text
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, config.ProjectConfigFileName), []byte("indent_size = 4\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Join(dir, "part.scad"))}).String()
+	text := "module a(){\ncube(1);\n}\nmodule b(){\ncube(2);\n}\n"
+
+	c := startServer(t)
+	var initResult initializeResult
+	c.request("initialize", map[string]any{"capabilities": map[string]any{}}, &initResult)
+	if !initResult.Capabilities.DocumentRangeFormattingProvider {
+		t.Error("expected range formatting capability")
+	}
+	c.notify("initialized", map[string]any{})
+
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": uri, "version": 1, "text": text}})
+	if diagnostics := c.diagnostics(); len(diagnostics) != 0 {
+		t.Errorf("expected no diagnostics, got %v", diagnostics)
+	}
+
+	var edits []textEdit
+	c.request("textDocument/rangeFormatting", map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"range":        textRange{Start: position{Line: 3}, End: position{Line: 5, Character: 1}},
+	}, &edits)
+	expected := "module a(){\ncube(1);\n}\nmodule b() {\n    cube(2);\n}\n"
+	if formatted := applyEdits(text, edits); formatted != expected {
+		t.Errorf("range formatting: expected %q, got %q", expected, formatted)
+	}
+
+	c.request("textDocument/formatting", map[string]any{"textDocument": map[string]any{"uri": uri}}, &edits)
+	expected = "module a() {\n    cube(1);\n}\nmodule b() {\n    cube(2);\n}\n"
+	if formatted := applyEdits(text, edits); formatted != expected {
+		t.Errorf("formatting: expected %q, got %q", expected, formatted)
+	}
+
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []map[string]any{{"text": "x = ;\n"}},
+	})
+	diagnostics := c.diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Range.Start != (position{Line: 0, Character: 4}) {
+		t.Errorf("expected a syntax error at 0:4, got %v", diagnostics)
+	}
+	c.request("textDocument/formatting", map[string]any{"textDocument": map[string]any{"uri": uri}}, &edits)
+	if len(edits) != 0 {
+		t.Errorf("expected no edits for a document with syntax errors, got %v", edits)
+	}
+
+	c.request("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnTypeFormatting(t *testing.T) {
+	text := "x=1;\nmodule a(){\ncube(1);\n}\ny=2;\n"
+	c := startServer(t)
+	c.request("initialize", map[string]any{"capabilities": map[string]any{}}, nil)
+	c.notify("textDocument/didOpen", map[string]any{"textDocument": map[string]any{"uri": "untitled:1", "version": 1, "text": text}})
+	c.diagnostics()
+
+	var edits []textEdit
+	c.request("textDocument/onTypeFormatting", map[string]any{
+		"textDocument": map[string]any{"uri": "untitled:1"},
+		"position":     position{Line: 3, Character: 1},
+		"ch":           "}",
+	}, &edits)
+	expected := "x=1;\nmodule a() {\n  cube(1);\n}\ny=2;\n"
+	if formatted := applyEdits(text, edits); formatted != expected {
+		t.Errorf("expected %q, got %q", expected, formatted)
+	}
+}
+
+func TestBlockStartLine(t *testing.T) {
+	text := "x=1;\nmodule a(){\necho(\"}\"); // }\nif (x) {\n/* { */ cube(1);\n}\n}\n"
+	for _, test := range []struct {
+		pos      position
+		expected int
+	}{
+		{position{Line: 5, Character: 1}, 3},
+		{position{Line: 6, Character: 1}, 1},
+		{position{Line: 2, Character: 14}, 2},
+	} {
+		if line := blockStartLine(text, test.pos); line != test.expected {
+			t.Errorf("%+v: expected line %d, got %d", test.pos, test.expected, line)
+		}
+	}
+}
+
+func TestLineEdits(t *testing.T) {
+	for _, test := range [][2]string{
+		{"a\nb\nc\n", "a\nB\nc\n"},
+		{"a\nb", "a\nb\n"},
+		{"x", "y"},
+		{"", "x\n"},
+		{"a\n\n\nb\n", "a\nb\n"},
+	} {
+		if output := applyEdits(test[0], lineEdits(test[0], test[1])); output != test[1] {
+			t.Errorf("%q: expected %q, got %q", test[0], test[1], output)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+// SCADFormat - Formatter / beautifier for OpenSCAD source code
+//
+// Copyright (C) 2025  Hugh Eaves
+//
+// This program is free software; you can redistribute it and/or
+// modify it under the terms of the GNU General Public License
+// as published by the Free Software Foundation; either version 2
+// of the License, or (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program; if not, write to the Free Software
+// Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA  02110-1301, USA.
+
+package lsp
+
+import (
+	"strings"
+
+	"github.com/antlr4-go/antlr/v4"
+	"github.com/hugheaves/scadformat/internal/formatter"
+	"github.com/hugheaves/scadformat/internal/parser"
+	"go.uber.org/zap"
+)
+
+// OpenSCAD has separate namespaces for modules, functions and variables.
+type symbolKind int
+
+const (
+	kindModule symbolKind = iota
+	kindFunction
+	kindVariable
+)
+
+// LSP symbol kinds
+const (
+	lspSymbolModule   = 2
+	lspSymbolFunction = 12
+	lspSymbolVariable = 13
+)
+
+func (k symbolKind) lspKind() int {
+	switch k {
+	case kindModule:
+		return lspSymbolModule
+	case kindFunction:
+		return lspSymbolFunction
+	default:
+		return lspSymbolVariable
+	}
+}
+
+// symbol is the definition of a module, function or variable (including
+// parameters and variables defined by let and for).
+type symbol struct {
+	name      string
+	kind      symbolKind
+	file      *fileIndex
+	nameRange textRange // the range of the name in the definition
+	fullRange textRange // the range of the whole definition
+	children  []*symbol // definitions within a module or function, for the document outline
+	outline   bool      // the symbol is shown in the document outline
+}
+
+// reference is a use of a module, function or variable name.
+type reference struct {
+	name  string
+	kind  symbolKind
+	rng   textRange
+	scope *scope
+}
+
+// scope contains the definitions that are visible within part of a file.
+type scope struct {
+	parent  *scope
+	symbols []*symbol
+}
+
+// lookup returns the definition of a name in the scope, or nil.
+func (s *scope) lookup(name string, kind symbolKind) *symbol {
+	// the last definition of a name takes effect, as in OpenSCAD
+	for i := len(s.symbols) - 1; i >= 0; i-- {
+		if s.symbols[i].name == name && s.symbols[i].kind == kind {
+			return s.symbols[i]
+		}
+	}
+	return nil
+}
+
+// fileImport is an include or use statement.
+type fileImport struct {
+	fileName string // the file name, as written in the statement
+	use      bool   // "use" rather than "include"
+}
+
+// fileIndex contains the definitions and references in a file.
+type fileIndex struct {
+	uri     string
+	path    string
+	lines   []string
+	outline []*symbol // top level definitions, with nested definitions as children
+	symbols []*symbol // all definitions
+	refs    []*reference
+	global  *scope
+	imports []fileImport
+}
+
+// indexFile parses source code, and finds the definitions and references in it.
+// If the code contains syntax errors, the index will be incomplete.
+func indexFile(uri string, path string, text string) *fileIndex {
+	file := &fileIndex{
+		uri:    uri,
+		path:   path,
+		lines:  strings.Split(text, "\n"),
+		global: &scope{},
+	}
+	tree, _ := formatter.Parse([]byte(text), zap.L())
+	if tree != nil {
+		indexer := &indexer{file: file}
+		indexer.walk(tree, file.global, nil)
+	}
+	return file
+}
+
+// indexer walks a parse tree, recording definitions and references.
+type indexer struct {
+	file *fileIndex
+}
+
+// walk indexes a parse tree node. Definitions are added to sc, and to the
+// children of parent (or the top level of the outline if parent is nil).
+func (x *indexer) walk(tree antlr.Tree, sc *scope, parent *symbol) {
+	switch ctx := tree.(type) {
+	case *parser.IncludeOrUseFileContext:
+		x.addImport(ctx)
+
+	case *parser.ModuleDefinitionContext:
+		module := x.define(ctx.ID(), ctx, kindModule, sc, parent, true)
+		inner := &scope{parent: sc}
+		x.defineParameters(ctx.Parameters(), inner, sc)
+		if ctx.Statement() != nil {
+			x.walk(ctx.Statement(), inner, module)
+		}
+
+	case *parser.FunctionDefinitionContext:
+		function := x.define(ctx.ID(), ctx, kindFunction, sc, parent, true)
+		inner := &scope{parent: sc}
+		x.defineParameters(ctx.Parameters(), inner, sc)
+		if ctx.Expr() != nil {
+			x.walk(ctx.Expr(), inner, function)
+		}
+
+	case *parser.FunctionLiteralExprContext:
+		inner := &scope{parent: sc}
+		x.defineParameters(ctx.Parameters(), inner, sc)
+		if ctx.Expr() != nil {
+			x.walk(ctx.Expr(), inner, parent)
+		}
+
+	case *parser.AssignmentContext:
+		x.define(ctx.ID(), ctx, kindVariable, sc, parent, true)
+		if ctx.Expr() != nil {
+			x.walk(ctx.Expr(), sc, parent)
+		}
+
+	case *parser.StatementsContext, *parser.ChildStatementsContext:
+		x.walkChildren(tree, &scope{parent: sc}, parent)
+
+	case *parser.SingleModuleInstantiationContext:
+		moduleID := ctx.ModuleId()
+		if moduleID == nil {
+			return
+		}
+		if moduleID.(*parser.ModuleIdContext).ID() != nil {
+			x.addReference(moduleID.(*parser.ModuleIdContext).ID(), kindModule, sc)
+		}
+		if moduleID.(*parser.ModuleIdContext).FOR() != nil || moduleID.(*parser.ModuleIdContext).LET() != nil {
+			// for and let define variables for their children
+			inner := &scope{parent: sc}
+			x.defineArguments(ctx.ParenArgs(), inner, parent)
+			if ctx.ChildStatement() != nil {
+				x.walk(ctx.ChildStatement(), inner, parent)
+			}
+			return
+		}
+		x.walkArguments(ctx.ParenArgs(), sc, parent)
+		if ctx.ChildStatement() != nil {
+			x.walk(ctx.ChildStatement(), sc, parent)
+		}
+
+	case *parser.ForStatementContext:
+		inner := &scope{parent: sc}
+		x.defineArguments(ctx.ParenArgs(), inner, parent)
+		if ctx.ChildStatement() != nil {
+			x.walk(ctx.ChildStatement(), inner, parent)
+		}
+
+	case *parser.LetExprContext:
+		inner := &scope{parent: sc}
+		x.defineArguments(ctx.ParenArgs(), inner, parent)
+		if ctx.Expr() != nil {
+			x.walk(ctx.Expr(), inner, parent)
+		}
+
+	case *parser.LetStatementComprehensionContext:
+		inner := &scope{parent: sc}
+		x.defineArguments(ctx.ParenArgs(), inner, parent)
+		if ctx.ListComprehensionElementsP() != nil {
+			x.walk(ctx.ListComprehensionElementsP(), inner, parent)
+		}
+
+	case *parser.ForStatementComprehensionContext:
+		inner := &scope{parent: sc}
+		for _, arguments := range ctx.AllArguments() {
+			x.defineArgumentList(arguments, inner, parent)
+		}
+		if ctx.Expr() != nil {
+			x.walk(ctx.Expr(), inner, parent)
+		}
+		if ctx.VectorElement() != nil {
+			x.walk(ctx.VectorElement(), inner, parent)
+		}
+
+	case *parser.ParenArgsContext:
+		x.walkArguments(ctx, sc, parent)
+
+	case *parser.CallContext:
+		x.walkCall(ctx, sc, parent)
+
+	case *parser.MemberAccessContext:
+		// member names (e.g. v.x) are not references
+
+	default:
+		x.walkChildren(tree, sc, parent)
+	}
+}
+
+func (x *indexer) walkChildren(tree antlr.Tree, sc *scope, parent *symbol) {
+	for _, child := range tree.GetChildren() {
+		x.walk(child, sc, parent)
+	}
+}
+
+// walkCall indexes a call expression. A name followed by arguments is a
+// function call, and any other name is a variable.
+func (x *indexer) walkCall(ctx *parser.CallContext, sc *scope, parent *symbol) {
+	primary, ok := ctx.Primary().(*parser.PrimaryContext)
+	if !ok {
+		return
+	}
+	accesses := ctx.AllAccess()
+	if id, ok := primary.Id().(*parser.IdContext); ok && id.ID() != nil {
+		kind := kindVariable
+		if len(accesses) > 0 {
+			if _, isCall := accesses[0].(*parser.FunctionAccessContext); isCall {
+				kind = kindFunction
+			}
+		}
+		x.addReference(id.ID(), kind, sc)
+	} else {
+		x.walk(primary, sc, parent)
+	}
+	for _, access := range accesses {
+		x.walk(access, sc, parent)
+	}
+}
+
+// walkArguments indexes the arguments of a module instantiation or function
+// call. The names of named arguments are not references.
+func (x *indexer) walkArguments(parenArgs parser.IParenArgsContext, sc *scope, parent *symbol) {
+	ctx, ok := parenArgs.(*parser.ParenArgsContext)
+	if !ok {
+		return
+	}
+	arguments, ok := ctx.Arguments().(*parser.ArgumentsContext)
+	if !ok {
+		return
+	}
+	for _, a := range arguments.AllArgument() {
+		argument := a.(*parser.ArgumentContext)
+		if argument.Expr() != nil {
+			x.walk(argument.Expr(), sc, parent)
+		} else if assignment, ok := argument.AssignmentExpression().(*parser.AssignmentExpressionContext); ok && assignment.Expr() != nil {
+			x.walk(assignment.Expr(), sc, parent)
+		}
+	}
+}
+
+// defineArguments defines the variables assigned in the arguments of let or for.
+func (x *indexer) defineArguments(parenArgs parser.IParenArgsContext, sc *scope, parent *symbol) {
+	if ctx, ok := parenArgs.(*parser.ParenArgsContext); ok {
+		x.defineArgumentList(ctx.Arguments(), sc, parent)
+	}
+}
+
+func (x *indexer) defineArgumentList(args parser.IArgumentsContext, sc *scope, parent *symbol) {
+	arguments, ok := args.(*parser.ArgumentsContext)
+	if !ok {
+		return
+	}
+	for _, a := range arguments.AllArgument() {
+		argument := a.(*parser.ArgumentContext)
+		if assignment, ok := argument.AssignmentExpression().(*parser.AssignmentExpressionContext); ok {
+			x.define(assignment.ID(), assignment, kindVariable, sc, parent, false)
+			if assignment.Expr() != nil {
+				x.walk(assignment.Expr(), sc, parent)
+			}
+		} else if argument.Expr() != nil {
+			x.walk(argument.Expr(), sc, parent)
+		}
+	}
+}
+
+// defineParameters defines the parameters of a module or function. Default
+// values are evaluated in the scope where the module or function is defined.
+func (x *indexer) defineParameters(params parser.IParametersContext, sc *scope, outer *scope) {
+	parameters, ok := params.(*parser.ParametersContext)
+	if !ok {
+		return
+	}
+	for _, p := range parameters.AllParameter() {
+		parameter := p.(*parser.ParameterContext)
+		if parameter.ID() != nil {
+			x.define(parameter.ID(), parameter, kindVariable, sc, nil, false)
+		} else if assignment, ok := parameter.AssignmentExpression().(*parser.AssignmentExpressionContext); ok {
+			x.define(assignment.ID(), assignment, kindVariable, sc, nil, false)
+			if assignment.Expr() != nil {
+				x.walk(assignment.Expr(), outer, nil)
+			}
+		}
+	}
+}
+
+// define records a definition. Symbols shown in the outline are added to the
+// children of parent, or to the top level of the outline.
+func (x *indexer) define(name antlr.TerminalNode, ctx antlr.ParserRuleContext, kind symbolKind, sc *scope, parent *symbol, outline bool) *symbol {
+	if name == nil {
+		return nil
+	}
+	s := &symbol{
+		name:      name.GetText(),
+		kind:      kind,
+		file:      x.file,
+		nameRange: x.tokenRange(name.GetSymbol()),
+		fullRange: textRange{Start: x.tokenRange(ctx.GetStart()).Start, End: x.tokenRange(ctx.GetStop()).End},
+		outline:   outline,
+	}
+	sc.symbols = append(sc.symbols, s)
+	x.file.symbols = append(x.file.symbols, s)
+	if outline {
+		if parent != nil {
+			parent.children = append(parent.children, s)
+		} else {
+			x.file.outline = append(x.file.outline, s)
+		}
+	}
+	return s
+}
+
+func (x *indexer) addReference(name antlr.TerminalNode, kind symbolKind, sc *scope) {
+	x.file.refs = append(x.file.refs, &reference{
+		name:  name.GetText(),
+		kind:  kind,
+		rng:   x.tokenRange(name.GetSymbol()),
+		scope: sc,
+	})
+}
+
+func (x *indexer) addImport(ctx *parser.IncludeOrUseFileContext) {
+	if ctx.INCLUDE_OR_USE_FILE() == nil {
+		return
+	}
+	text := ctx.INCLUDE_OR_USE_FILE().GetText()
+	start := strings.Index(text, "<")
+	end := strings.LastIndex(text, ">")
+	if start < 0 || end < start {
+		return
+	}
+	x.file.imports = append(x.file.imports, fileImport{
+		fileName: text[start+1 : end],
+		use:      strings.HasPrefix(text, "use"),
+	})
+}
+
+// tokenRange returns the range of a token in the file.
+func (x *indexer) tokenRange(token antlr.Token) textRange {
+	if token == nil || token.GetLine() < 1 || token.GetLine() > len(x.file.lines) {
+		return textRange{}
+	}
+	line := token.GetLine() - 1
+	start := position{Line: line, Character: utf16Column(x.file.lines[line], token.GetColumn())}
+	// tokens (e.g. strings) may span lines
+	tokenLines := strings.Split(token.GetText(), "\n")
+	end := position{Line: line + len(tokenLines) - 1}
+	if len(tokenLines) == 1 {
+		end.Character = start.Character + utf16Length(tokenLines[0])
+	} else {
+		end.Character = utf16Length(tokenLines[len(tokenLines)-1])
+	}
+	return textRange{Start: start, End: end}
+}